@@ -2,10 +2,14 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/docopt/docopt-go"
 	"github.com/itzamna314/azb.go/lib"
@@ -24,17 +28,41 @@ func main() {
 }
 
 func doit() (err error) {
-	res, err := usage(os.Args[1:])
+	argv, expression := splitFindExpression(os.Args[1:])
+	res, err := usage(argv)
 	if err != nil {
 		usage([]string{"azb", "--help"})
 		return
 	}
+	res["<expression>"] = expression
 
 	// load config
-	configFile := res["-F"].(string)
-	environment := res["-e"].(string)
+	configFile, _ := res["-F"].(string)
+	environment, _ := res["-e"].(string)
 
-	conf, err := lib.GetConfig(configFile, environment)
+	ctx := trapSignals()
+
+	// config commands inspect the configuration, so they must not require
+	// a valid one
+	if res["config"].(bool) {
+		cmd := CreateConfigCommand(configFile, environment, res)
+		return handleErr(cmd.Dispatch(ctx))
+	}
+
+	// comparing inventories only reads local files
+	if res["inventory"].(bool) && res["diff"].(bool) {
+		cmd := createInventoryDiffCommand(res)
+		return handleErr(cmd.Dispatch(ctx))
+	}
+
+	// accounts commands manage the subscription, so they need management
+	// credentials rather than a storage account
+	var conf *lib.AzbConfig
+	if res["accounts"].(bool) {
+		conf, err = lib.GetManagementConfig(configFile, environment)
+	} else {
+		conf, err = lib.GetConfig(configFile, environment)
+	}
 	if err != nil {
 		return err
 	}
@@ -44,7 +72,26 @@ func doit() (err error) {
 		return err
 	}
 
-	return handleErr(cmd.Dispatch())
+	return handleErr(cmd.Dispatch(ctx))
+}
+
+// trapSignals returns a context that is cancelled on the first SIGINT or
+// SIGTERM, giving the running command a chance to stop cleanly.  A second
+// signal exits immediately.
+func trapSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sigs
+		cancel()
+		<-sigs
+		os.Exit(130)
+	}()
+
+	return ctx
 }
 
 func handleErr(err error) error {
@@ -54,9 +101,18 @@ func handleErr(err error) error {
 	} else if err == lib.ErrContainerNotFound {
 		fmt.Println("azb: No such container")
 		os.Exit(1)
+	} else if err == lib.ErrStorageAccountNotFound {
+		fmt.Println("azb: No such storage account")
+		os.Exit(1)
 	} else if err == lib.ErrUnrecognizedCommand {
 		fmt.Println("azb: unexpected arguments")
 		os.Exit(1)
+	} else if err == lib.ErrInterrupted {
+		fmt.Println("azb: interrupted")
+		os.Exit(130)
+	} else if err == lib.ErrConfigTestFailed || err == lib.ErrIncompleteResult {
+		// the failure has already been reported
+		os.Exit(1)
 	}
 
 	return err
@@ -72,8 +128,39 @@ func usage(argv []string) (map[string]interface{}, error) {
 	return dict, err
 }
 
-func blobSpec(path string, requirePath bool) (*lib.BlobSpec, error) {
-	src, err := lib.ParseBlobSpec(path)
+// splitFindExpression takes find's expression off the end of argv, since
+// docopt would read its predicates as options
+func splitFindExpression(argv []string) ([]string, []string) {
+	for i := 0; i < len(argv); i++ {
+		switch argv[i] {
+		case "-F", "-e", "-w":
+			// skip the option's value
+			i++
+		case "find":
+			for j := i + 1; j < len(argv); j++ {
+				if argv[j] != "--regex" && argv[j] != "-f" {
+					return argv[:j+1], argv[j+1:]
+				}
+			}
+			return argv, nil
+		default:
+			if !strings.HasPrefix(argv[i], "-") {
+				// some other command
+				return argv, nil
+			}
+		}
+	}
+
+	return argv, nil
+}
+
+func blobSpec(path string, requirePath, regex bool) (*lib.BlobSpec, error) {
+	parse := lib.ParseBlobSpec
+	if regex {
+		parse = lib.ParseBlobSpecRegex
+	}
+
+	src, err := parse(path)
 	if err != nil {
 		return nil, err
 	} else if requirePath && !src.PathPresent {
@@ -90,6 +177,12 @@ func CreateCommand(cfg *lib.AzbConfig, res map[string]interface{}) (lib.Command,
 		mode = "json"
 	}
 
+	// --ndjson is short for --format ndjson
+	format, _ := res["--format"].(string)
+	if res["--ndjson"].(bool) {
+		format = "ndjson"
+	}
+
 	w, err := strconv.Atoi(res["-w"].(string))
 	if err != nil {
 		fmt.Printf("Usage: expected -w to be an int, was %s\n", res["-w"].(string))
@@ -97,47 +190,115 @@ func CreateCommand(cfg *lib.AzbConfig, res map[string]interface{}) (lib.Command,
 	}
 
 	logger := lib.CreateLogger(res["-v"].(bool), res["-s"].(bool))
+	cfg.Retry.Logger = logger
 
 	var cmd lib.Command
 
 	var blobSrc, blobDst, localPath *string
 	requireBlobPath := false
+	regex := res["--regex"].(bool)
+
+	// cost reads --older-than as its own threshold rather than a filter
+	var filter lib.BlobFilter
+	if !res["cost"].(bool) {
+		if filter, err = blobFilter(res); err != nil {
+			return nil, err
+		}
+	}
 
 	// dispatch ls
 	switch {
 	case res["ls"].(bool):
-		cmd = &lib.SimpleCommand{Command: "ls"}
+		ls := &lib.SimpleCommand{
+			Command:    "ls",
+			Recursive:  res["-R"].(bool),
+			LongFormat: res["-l"].(bool),
+			HumanSizes: res["-H"].(bool),
+			SortBy:     res["--sort"].(string),
+			Reverse:    res["--reverse"].(bool),
+			Format:     format,
+		}
+		if _, ok := res["--max-results"].(string); ok {
+			ls.MaxResults = intOption("--max-results", res)
+		}
+		ls.Marker, _ = res["--marker"].(string)
+		cmd = ls
 		blobSrc = stringOrDefault("<blobspec>", res, true)
 		break
 	case res["tree"].(bool):
-		cmd = &lib.SimpleCommand{Command: "tree"}
-		blobSrc = stringOrDefault("<container>", res, true)
+		tree := &lib.SimpleCommand{
+			Command:    "tree",
+			SortBy:     res["--sort"].(string),
+			Reverse:    res["--reverse"].(bool),
+			DirsFirst:  res["--dirsfirst"].(bool),
+			HumanSizes: res["-H"].(bool),
+			BlobSizes:  res["--sizes"].(bool),
+			DiskUsage:  res["--du"].(bool),
+			DirsOnly:   res["-d"].(bool),
+			Charset:    res["--charset"].(string),
+			Color:      !res["--no-color"].(bool) && isTerminal(os.Stdout),
+			Format:     format,
+		}
+		if _, ok := res["-L"].(string); ok {
+			tree.Depth = intOption("-L", res)
+		}
+		cmd = tree
+		blobSrc = stringOrDefault("<blobspec>", res, true)
 		break
 	case res["get"].(bool):
-		cmd = &lib.SimpleCommand{Command: "get"}
+		get := &lib.SimpleCommand{Command: "get", Recursive: res["-r"].(bool), Format: format}
+		get.Range, _ = res["--range"].(string)
+		cmd = get
 		blobSrc = stringOrDefault("<blobpath>", res, true)
 		localPath = stringOrDefault("<dst>", res, false)
 		requireBlobPath = true
 		break
 	case res["rm"].(bool):
-		cmd = &lib.SimpleCommand{Command: "rm"}
+		cmd = &lib.SimpleCommand{Command: "rm", Recursive: res["-r"].(bool)}
 		blobSrc = stringOrDefault("<blobpath>", res, true)
 		requireBlobPath = true
 		break
+	case res["find"].(bool):
+		find := &lib.SimpleCommand{Command: "find"}
+		find.Expression, _ = res["<expression>"].([]string)
+		cmd = find
+		blobSrc = stringOrDefault("<blobspec>", res, true)
+		break
 	case res["put"].(bool):
 		cmd = &lib.SimpleCommand{Command: "put"}
 		blobDst = stringOrDefault("<blobpath>", res, true)
 		localPath = stringOrDefault("<src>", res, true)
 		break
-	case res["size"].(bool):
-		cmd = &lib.SizeCommand{}
+	case res["cat"].(bool), res["head"].(bool), res["tail"].(bool):
+		cat, err := createCatCommand(res)
+		if err != nil {
+			return nil, err
+		}
+		cmd = cat
+
+		// cat takes several blobs, like size
+		for _, src := range stringsOrDefault("<blobpaths>", res, false) {
+			bs, err := blobSpec(src, true, regex)
+			if err != nil {
+				return nil, err
+			}
+			cmd.AddSource(bs)
+		}
+		break
+	case res["accounts"].(bool):
+		cmd = createAccountsCommand(res)
+		break
+	case res["size"].(bool), res["du"].(bool), res["cost"].(bool), res["inventory"].(bool):
+		cmd = createSizeCommand(res)
+
 		// Special handling - size accepts a slice of blobspec
 		blobSrcs := stringsOrDefault("<blobspecs>", res, true)
 		for _, src := range blobSrcs {
-			bs, err := blobSpec(src, requireBlobPath)
+			bs, err := blobSpec(src, requireBlobPath, regex)
 			if err != nil {
 				return nil, err
 			}
+			bs.Filter = filter
 			cmd.AddSource(bs)
 		}
 		break
@@ -150,16 +311,17 @@ func CreateCommand(cfg *lib.AzbConfig, res map[string]interface{}) (lib.Command,
 	cmd.SetLogger(logger)
 
 	if blobSrc != nil {
-		src, err := blobSpec(*blobSrc, requireBlobPath)
+		src, err := blobSpec(*blobSrc, requireBlobPath, regex)
 		if err != nil {
 			return nil, err
 		}
+		src.Filter = filter
 
 		cmd.AddSource(src)
 	}
 
 	if blobDst != nil {
-		dst, err := blobSpec(*blobDst, false)
+		dst, err := blobSpec(*blobDst, false, false)
 		if err != nil {
 			return nil, err
 		}
@@ -174,6 +336,192 @@ func CreateCommand(cfg *lib.AzbConfig, res map[string]interface{}) (lib.Command,
 	return cmd, nil
 }
 
+// createCatCommand sets up cat, head or tail
+func createCatCommand(res map[string]interface{}) (*lib.CatCommand, error) {
+	cmd := &lib.CatCommand{Command: "cat"}
+	switch {
+	case res["head"].(bool):
+		cmd.Command = "head"
+	case res["tail"].(bool):
+		cmd.Command = "tail"
+	default:
+		return cmd, nil
+	}
+
+	cmd.Lines = intOption("-n", res)
+	if s, ok := res["-c"].(string); ok {
+		n, err := lib.ParseSize(s)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Bytes = n
+	}
+
+	return cmd, nil
+}
+
+// createSizeCommand sets up size, or one of the commands built on its workers
+func createSizeCommand(res map[string]interface{}) lib.Command {
+	size := lib.SizeCommand{
+		KeepGoing: res["--keep-going"].(bool),
+		Histogram: res["--histogram"].(bool),
+	}
+	size.GroupBy, _ = res["--group-by"].(string)
+
+	switch {
+	case res["du"].(bool):
+		return &lib.DuCommand{
+			Depth:       intOption("--depth", res),
+			SortBySize:  res["--by-size"].(bool),
+			SizeCommand: size,
+		}
+	case res["cost"].(bool):
+		cmd := &lib.CostCommand{OlderThanDays: 90, SizeCommand: size}
+		if s, ok := res["--older-than"].(string); ok {
+			days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+			if err != nil || days < 0 {
+				fmt.Printf("Usage: expected --older-than to be a number of days, was %s\n", s)
+				os.Exit(1)
+			}
+			cmd.OlderThanDays = days
+		}
+		cmd.PriceFile, _ = res["--prices"].(string)
+		return cmd
+	case res["inventory"].(bool):
+		cmd := &lib.InventoryCommand{SizeCommand: size}
+		cmd.Output, _ = res["-o"].(string)
+		cmd.Format, _ = res["--format"].(string)
+		return cmd
+	}
+
+	return &size
+}
+
+// blobFilter reads the options that narrow listings by age and size
+func blobFilter(res map[string]interface{}) (f lib.BlobFilter, err error) {
+	now := time.Now()
+	if s, ok := res["--newer-than"].(string); ok {
+		if f.NewerThan, err = lib.ParseAge(s, now); err != nil {
+			return f, err
+		}
+	}
+	if s, ok := res["--older-than"].(string); ok {
+		if f.OlderThan, err = lib.ParseAge(s, now); err != nil {
+			return f, err
+		}
+	}
+	if s, ok := res["--min-size"].(string); ok {
+		if f.MinSize, err = lib.ParseSize(s); err != nil {
+			return f, err
+		}
+	}
+	if s, ok := res["--max-size"].(string); ok {
+		if f.MaxSize, err = lib.ParseSize(s); err != nil {
+			return f, err
+		}
+		f.HasMaxSize = true
+	}
+
+	return f, nil
+}
+
+// isTerminal reports whether f is a terminal, rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// intOption reads an option that must be a non-negative integer
+func intOption(key string, res map[string]interface{}) int {
+	n, err := strconv.Atoi(res[key].(string))
+	if err != nil || n < 0 {
+		fmt.Printf("Usage: expected %s to be a non-negative int, was %s\n", key, res[key].(string))
+		os.Exit(1)
+	}
+
+	return n
+}
+
+func createInventoryDiffCommand(res map[string]interface{}) lib.Command {
+	cmd := &lib.InventoryCommand{Subcommand: "diff"}
+	older, _ := res["<old>"].(string)
+	newer, _ := res["<new>"].(string)
+	cmd.Files = []string{older, newer}
+
+	if res["--json"].(bool) {
+		cmd.SetOutputMode("json")
+	} else {
+		cmd.SetOutputMode("bare")
+	}
+	cmd.SetLogger(lib.CreateLogger(res["-v"].(bool), res["-s"].(bool)))
+
+	return cmd
+}
+
+func createAccountsCommand(res map[string]interface{}) lib.Command {
+	cmd := &lib.AccountsCommand{}
+	cmd.Account, _ = res["<account>"].(string)
+	cmd.Target, _ = res["<env>"].(string)
+	cmd.ConfigFile, _ = res["-F"].(string)
+
+	switch {
+	case res["list"].(bool):
+		cmd.Subcommand = "list"
+	case res["show"].(bool):
+		cmd.Subcommand = "show"
+	case res["keys"].(bool):
+		cmd.Subcommand = "keys"
+	case res["regenerate"].(bool):
+		cmd.Subcommand = "regenerate"
+		if res["secondary"].(bool) {
+			cmd.KeyType = "secondary"
+		} else {
+			cmd.KeyType = "primary"
+		}
+	case res["configure"].(bool):
+		cmd.Subcommand = "configure"
+	}
+
+	return cmd
+}
+
+func CreateConfigCommand(configFile, environment string, res map[string]interface{}) lib.Command {
+	cmd := &lib.ConfigCommand{
+		ConfigFile:  configFile,
+		Environment: environment,
+	}
+
+	switch {
+	case res["show"].(bool):
+		cmd.Subcommand = "show"
+	case res["list"].(bool):
+		cmd.Subcommand = "list"
+	case res["add"].(bool):
+		cmd.Subcommand = "add"
+		cmd.Settings, _ = res["<settings>"].([]string)
+	case res["remove"].(bool):
+		cmd.Subcommand = "remove"
+	case res["set"].(bool):
+		cmd.Subcommand = "set"
+		cmd.Key = res["<key>"].(string)
+		cmd.Value = res["<value>"].(string)
+	case res["test"].(bool):
+		cmd.Subcommand = "test"
+	}
+
+	cmd.Target, _ = res["<env>"].(string)
+
+	if res["--json"].(bool) {
+		cmd.SetOutputMode("json")
+	} else {
+		cmd.SetOutputMode("bare")
+	}
+	cmd.SetDestructive(res["-f"].(bool))
+	cmd.SetLogger(lib.CreateLogger(res["-v"].(bool), res["-s"].(bool)))
+
+	return cmd
+}
+
 func stringOrDefault(key string, dict map[string]interface{}, stdIn bool) (s *string) {
 	s = new(string)
 
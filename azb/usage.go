@@ -3,34 +3,111 @@ package main
 var usageMsg = `azb - an uncomplicated azure blob storage client
 
 Usage:
-  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] ls [ <blobspec> ]
-  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] tree <container>
-  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] get <blobpath> [ <dst> ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json | --ndjson | --format fmt ] ls [ --regex ] [ -R ] [ -l ] [ -H ] [ --sort key ] [ --reverse ] [ --max-results n ] [ --marker token ] [ --newer-than age ] [ --older-than age ] [ --min-size size ] [ --max-size size ] [ <blobspec> ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json | --ndjson | --format fmt ] [ -w workers ] tree [ --regex ] [ -L level ] [ -d ] [ --sizes ] [ --du ] [ -H ] [ --charset charset ] [ --no-color ] [ --sort key ] [ --reverse ] [ --dirsfirst ] [ --newer-than age ] [ --older-than age ] [ --min-size size ] [ --max-size size ] [ <blobspec> ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json | --ndjson | --format fmt ] get [ --regex ] [ -r ] [ --range range ] [ --newer-than age ] [ --older-than age ] [ --min-size size ] [ --max-size size ] <blobpath> [ <dst> ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] cat [ --regex ] <blobpaths>...
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] head [ --regex ] [ -n lines | -c bytes ] <blobpaths>...
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] tail [ --regex ] [ -n lines | -c bytes ] <blobpaths>...
   azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] put <blobpath> [ <src> ]
-  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] rm [ -f ] <blobpath>
-  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] [ -w workers ] size [ - | <blobspecs>... ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] rm [ --regex ] [ -r ] [ -f ] [ --newer-than age ] [ --older-than age ] [ --min-size size ] [ --max-size size ] <blobpath>
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] find [ --regex ] [ -f ] <blobspec> [ <expression>... ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] [ -w workers ] size [ --regex ] [ --keep-going ] [ --group-by key ] [ --histogram ] [ --newer-than age ] [ --older-than age ] [ --min-size size ] [ --max-size size ] [ - | <blobspecs>... ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] [ -w workers ] du [ --regex ] [ --keep-going ] [ --depth depth ] [ --by-size ] [ - | <blobspecs>... ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] [ -w workers ] cost [ --regex ] [ --keep-going ] [ --prices priceFile ] [ --older-than age ] [ - | <blobspecs>... ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] [ -w workers ] inventory [ --regex ] [ --keep-going ] [ --format fmt ] -o file [ - | <blobspecs>... ]
+  azb [-v] [-s] [ --json ] inventory diff <old> <new>
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] config show
+  azb [ -F configFile ] [-v] [-s] [ --json ] config list
+  azb [ -F configFile ] [-v] [-s] config add <env> [ <settings>... ]
+  azb [ -F configFile ] [-v] [-s] config remove [ -f ] <env>
+  azb [ -F configFile ] [-v] [-s] config set <env> <key> <value>
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] config test [ <env> ]
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] accounts list
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] accounts show <account>
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] accounts keys <account>
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] [ --json ] accounts regenerate [ -f ] <account> ( primary | secondary )
+  azb [ -F configFile ] [ -e environment ] [-v] [-s] accounts configure <account> <env>
   azb -h | --help
   azb --version
 
 Arguments:
-  container   The name of the container to query
-  blobspec    A reference to one or more blobs (e.g. "mycontainer/foo", "mycontainer/")
+  blobspec    A reference to one or more blobs (e.g. "mycontainer/foo", "mycontainer/", "mycontainer/**/*.gz")
   blobpath    The path of a blob (e.g. "mycontainer/foo.txt")
+  blobpaths   One or more blobs, by path or pattern (e.g. "logs/app.log", "logs/2016/*.log")
+  expression  find(1)-style tests (-name, -path, -size, -mtime, -type) joined by -and, -or, -not and ( ),
+              and actions (-print, -print0, -delete, -exec cmd {} ;).  Must follow the blobspec.
+  account     The name of a storage account in the subscription
+  env         The name of an environment section in the configuration
+  old         An earlier inventory file
+  new         A later inventory file, to compare with the earlier
+  settings    Configuration settings as key=value (e.g. storage_account_name=myaccount)
 
 Options:
-  -e environment  Specifies the Azure Storage Services account to use [default: default]
-  -F configFile   Specifies an alternative per-user configuration file [default: /usr/local/etc/.azb.toml]
+  -e environment  Specifies the Azure Storage Services account to use (default: $AZB_ENVIRONMENT or "default")
+  -F configFile   Specifies a configuration file to use instead of the search path
   -f              Forces a destructive operation
+  -R              Lists every blob under a path, rather than one level
+  -r              Downloads or removes every blob under a path
+  --regex         Treats the path of a blobspec as a regular expression, rather than a glob
+  -l              Lists sizes, times, content types and ETags alongside names
+  -H              Shows sizes in KB, MB, etc.
+  --sort key      Sorts ls and tree output by name, size or time [default: name]
+  --reverse       Reverses the sort order
+  --dirsfirst     Lists directories ahead of blobs in tree
+  -L level        Descends at most level directories in tree
+  --sizes         Shows the size of each blob in tree, as tree -s does
+  --du            Shows the total size and number of blobs under each directory in tree
+  -d              Shows only directories in tree
+  --charset charset  Draws tree lines with ascii or unicode characters [default: unicode]
+  --no-color      Leaves tree uncolored; by default, a terminal shows directories and large blobs in color
+  --range range   Downloads only the bytes start-end of a blob, counting from 0 (e.g. 0-1023, or 1024- for the rest)
+  -n lines        Prints the first or last lines of each blob [default: 10]
+  -c bytes        Prints the first or last bytes of each blob (or k, M, G), rather than lines
+  --ndjson        Prints one JSON object per line, as ls gets each page; short for --format ndjson
+  --format fmt    Prints each blob or container as csv, tsv, ndjson, yaml, or by a Go template
+                  (e.g. '{{.Name}}\t{{.ContentLength}}')
+  --max-results n  Lists at most n entries, then prints the marker to resume from
+  --marker token  Resumes a listing at the marker an earlier ls printed
+  -o file         Writes an inventory to file, as csv or ndjson by --format or its extension; .gz compresses
   -w workers      The maximum number of concurrent workers to use [default: 10]
+  --keep-going    Report partial results when some sources fail
+  --depth depth   The number of directory levels du breaks sizes down by [default: 1]
+  --by-size       Sorts du output by size, largest first
+  --group-by key  Also totals size by content-type, extension or month
+  --histogram     Also counts blobs by size
+  --prices priceFile  A TOML file of prices per GB-month by tier, instead of the configured prices
+  --older-than age    Selects blobs last modified before a time or age (e.g. 2016-05-01, 36h, 7d);
+                      for cost, the age in days at which to consider a cheaper tier (default: 90)
+  --newer-than age    Selects blobs last modified after a time or age
+  --min-size size     Selects blobs of at least size bytes (or k, M, G, T)
+  --max-size size     Selects blobs of at most size bytes (or k, M, G, T)
   -h, --help      Show this screen.
   -v              Verbose mode - show detailed output
   -s              Silent mode - no output
   --version       Show version.
 
 The most commonly used commands are:
-  ls           Lists containers and blobs
+  ls           Lists containers, and blobs one level at a time
   get          Downloads a blob
+  cat          Prints blobs, one after another
+  head         Prints the start of blobs
+  tail         Prints the end of blobs
   put          Uploads a blob
-  tree         Prints the contents of a container as a tree
+  tree         Prints the contents of the account, a container, or a path in one, as a tree
+  size         Totals the size of blobs
+  du           Breaks down the size of blobs by container and directory
+  cost         Estimates the monthly cost of storing blobs, at hot-tier prices
+  inventory    Writes every blob's properties to a file, or compares two such files
   rm           Deletes a blob
+  find         Finds blobs by name, size, age or type, and acts on them
+  config       Shows, edits and tests the configured environments
+  accounts     Manages the storage accounts of a subscription
+
+Configuration is read from AZB_* environment variables, then .azb.toml in the
+current directory or its nearest parent, then $XDG_CONFIG_HOME/azb/config.toml,
+then /usr/local/etc/.azb.toml.  Earlier sources take precedence.  config add,
+remove and set rewrite the file they change, dropping any comments in it.
+The retry_* settings apply to blob storage calls only; accounts commands
+retry as the management client does.
 `
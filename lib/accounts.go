@@ -0,0 +1,337 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/management"
+	"github.com/Azure/azure-sdk-for-go/management/storageservice"
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+var (
+	ErrStorageAccountNotFound = errors.New("storage account not found")
+	ErrNoBlobEndpoint         = errors.New("storage account has no blob endpoint")
+)
+
+const azureStorageServiceRegenerateKeysURL = "services/storageservices/%s/keys?action=regenerate"
+
+// AccountsCommand manages the storage accounts of a subscription through the
+// service management API.  It needs an environment with a subscription_id
+// and management_certificate.
+type AccountsCommand struct {
+	Subcommand  string
+	Account     string // storage account to operate on
+	KeyType     string // accounts regenerate: "primary" or "secondary"
+	Target      string // accounts configure: environment to create
+	ConfigFile  string // accounts configure: file to write to
+	config      *AzbConfig
+	outputMode  string
+	destructive bool
+	logger      Logger
+}
+
+// Command interface
+func (cmd *AccountsCommand) SetConfig(cfg *AzbConfig)  { cmd.config = cfg }
+func (cmd *AccountsCommand) Config() *AzbConfig        { return cmd.config }
+func (cmd *AccountsCommand) AddSource(blob *BlobSpec)  {}
+func (cmd *AccountsCommand) SetDst(blob *BlobSpec)     {}
+func (cmd *AccountsCommand) SetLocalPath(path string)  {}
+func (cmd *AccountsCommand) SetOutputMode(mode string) { cmd.outputMode = mode }
+func (cmd *AccountsCommand) OutputMode() string        { return cmd.outputMode }
+func (cmd *AccountsCommand) SetDestructive(b bool)     { cmd.destructive = b }
+func (cmd *AccountsCommand) SetWorkers(n int)          {}
+func (cmd *AccountsCommand) SetLogger(l Logger)        { cmd.logger = l }
+func (cmd *AccountsCommand) Logger() Logger            { return cmd.logger }
+
+func (cmd *AccountsCommand) Dispatch(ctx context.Context) error {
+	switch cmd.Subcommand {
+	case "list":
+		return cmd.list()
+	case "show":
+		return cmd.show()
+	case "keys":
+		return cmd.keys()
+	case "regenerate":
+		return cmd.regenerate()
+	case "configure":
+		return cmd.configure()
+	default:
+		return ErrUnrecognizedCommand
+	}
+}
+
+type account struct {
+	Name                  string   `json:"name"`
+	Label                 string   `json:"label,omitempty"`
+	Description           string   `json:"description,omitempty"`
+	Location              string   `json:"location"`
+	Status                string   `json:"status"`
+	GeoReplicationEnabled string   `json:"geoReplicationEnabled,omitempty"`
+	GeoPrimaryRegion      string   `json:"geoPrimaryRegion,omitempty"`
+	Endpoints             []string `json:"endpoints"`
+}
+
+func newAccount(s storageservice.StorageServiceResponse) *account {
+	p := s.StorageServiceProperties
+	return &account{
+		Name:                  s.ServiceName,
+		Label:                 p.Label,
+		Description:           p.Description,
+		Location:              p.Location,
+		Status:                p.Status,
+		GeoReplicationEnabled: p.GeoReplicationEnabled,
+		GeoPrimaryRegion:      p.GeoPrimaryRegion,
+		Endpoints:             p.Endpoints,
+	}
+}
+
+type accountKeys struct {
+	Name      string `json:"name"`
+	Primary   string `json:"primary"`
+	Secondary string `json:"secondary"`
+}
+
+func (cmd *AccountsCommand) list() error {
+	client, err := cmd.config.getStorageService()
+	if err != nil {
+		return err
+	}
+
+	res, err := client.ListStorageServices()
+	if err != nil {
+		return handleManagementError(err)
+	}
+
+	arr := []*account{}
+	for _, s := range res.StorageServices {
+		arr = append(arr, newAccount(s))
+	}
+
+	if cmd.outputMode == "json" {
+		tmp := struct {
+			Subscription string     `json:"subscription"`
+			Accounts     []*account `json:"accounts"`
+		}{
+			Subscription: cmd.config.SubscriptionID,
+			Accounts:     arr,
+		}
+
+		s, _ := json.Marshal(tmp)
+		cmd.logger.Info("%s\n", s)
+	} else {
+		for _, a := range arr {
+			cmd.logger.Info("%s\n", a.Name)
+		}
+		cmd.logger.Debug("Found %d storage accounts\n", len(arr))
+	}
+
+	return nil
+}
+
+func (cmd *AccountsCommand) show() error {
+	a, err := cmd.getAccount()
+	if err != nil {
+		return err
+	}
+
+	if cmd.outputMode == "json" {
+		s, _ := json.Marshal(a)
+		cmd.logger.Info("%s\n", s)
+	} else {
+		cmd.logger.Info("Name:        %s\n", a.Name)
+		cmd.logger.Info("Label:       %s\n", a.Label)
+		cmd.logger.Info("Description: %s\n", a.Description)
+		cmd.logger.Info("Location:    %s\n", a.Location)
+		cmd.logger.Info("Status:      %s\n", a.Status)
+		cmd.logger.Info("Geo-replicated: %s (primary region %s)\n", a.GeoReplicationEnabled, a.GeoPrimaryRegion)
+		cmd.logger.Info("Endpoints:\n")
+		for _, e := range a.Endpoints {
+			cmd.logger.Info("  %s\n", e)
+		}
+	}
+
+	return nil
+}
+
+func (cmd *AccountsCommand) getAccount() (*account, error) {
+	client, err := cmd.config.getStorageService()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.GetStorageService(cmd.Account)
+	if err != nil {
+		return nil, handleManagementError(err)
+	}
+
+	return newAccount(res), nil
+}
+
+func (cmd *AccountsCommand) getKeys() (*accountKeys, error) {
+	client, err := cmd.config.getStorageService()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.GetStorageServiceKeys(cmd.Account)
+	if err != nil {
+		return nil, handleManagementError(err)
+	}
+
+	return &accountKeys{cmd.Account, res.PrimaryKey, res.SecondaryKey}, nil
+}
+
+func (cmd *AccountsCommand) keys() error {
+	keys, err := cmd.getKeys()
+	if err != nil {
+		return err
+	}
+
+	cmd.keysReport(keys)
+
+	return nil
+}
+
+func (cmd *AccountsCommand) keysReport(keys *accountKeys) {
+	if cmd.outputMode == "json" {
+		s, _ := json.Marshal(keys)
+		cmd.logger.Info("%s\n", s)
+	} else {
+		cmd.logger.Info("primary   %s\n", keys.Primary)
+		cmd.logger.Info("secondary %s\n", keys.Secondary)
+	}
+}
+
+func (cmd *AccountsCommand) regenerate() error {
+	var keyType string
+	switch cmd.KeyType {
+	case "primary":
+		keyType = "Primary"
+	case "secondary":
+		keyType = "Secondary"
+	default:
+		return ErrUnrecognizedCommand
+	}
+
+	if cmd.destructive == false {
+		cmd.logger.Info("Would regenerate the %s key of %s\n", cmd.KeyType, cmd.Account)
+		return nil
+	}
+
+	// The storageservice client has no call for this, so go through the
+	// management client it wraps
+	client, err := cmd.config.getManagementClient()
+	if err != nil {
+		return err
+	}
+
+	keys, err := regenerateStorageKeys(client, cmd.Account, keyType)
+	if err != nil {
+		return handleManagementError(err)
+	}
+
+	cmd.keysReport(keys)
+
+	return nil
+}
+
+func regenerateStorageKeys(client management.Client, name, keyType string) (*accountKeys, error) {
+	req := struct {
+		XMLName xml.Name `xml:"http://schemas.microsoft.com/windowsazure RegenerateKeys"`
+		KeyType string
+	}{KeyType: keyType}
+
+	data, err := xml.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	requestURL := fmt.Sprintf(azureStorageServiceRegenerateKeysURL, name)
+	body, err := client.SendAzurePostRequestWithReturnedResponse(requestURL, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var res storageservice.GetStorageServiceKeysResponse
+	if err := xml.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	return &accountKeys{name, res.PrimaryKey, res.SecondaryKey}, nil
+}
+
+// configure writes an environment for the account, so that the storage
+// commands can be pointed at it with -e
+func (cmd *AccountsCommand) configure() error {
+	a, err := cmd.getAccount()
+	if err != nil {
+		return err
+	}
+
+	keys, err := cmd.getKeys()
+	if err != nil {
+		return err
+	}
+
+	section := map[string]interface{}{
+		"storage_account_name":       a.Name,
+		"storage_account_access_key": keys.Primary,
+	}
+
+	// Carry over a non-default cloud or protocol from the account's endpoint
+	endpoint, useHTTPS, err := blobEndpoint(a)
+	if err != nil {
+		return err
+	}
+	if endpoint != storage.DefaultBaseURL {
+		section["blob_endpoint"] = endpoint
+	}
+	if !useHTTPS {
+		section["protocol"] = "http"
+	}
+
+	path, err := addEnvironment(cmd.ConfigFile, cmd.Target, section)
+	if err != nil {
+		return err
+	}
+
+	cmd.logger.Info("Added environment %s for storage account %s to %s\n", cmd.Target, a.Name, path)
+
+	return nil
+}
+
+// blobEndpoint finds the base domain of an account's blob service, e.g.
+// https://myaccount.blob.core.windows.net/ yields core.windows.net
+func blobEndpoint(a *account) (string, bool, error) {
+	prefix := a.Name + ".blob."
+
+	for _, e := range a.Endpoints {
+		u, err := url.Parse(e)
+		if err != nil {
+			continue
+		}
+
+		if strings.HasPrefix(u.Host, prefix) {
+			return strings.TrimPrefix(u.Host, prefix), u.Scheme == "https", nil
+		}
+	}
+
+	return "", false, ErrNoBlobEndpoint
+}
+
+func handleManagementError(err error) error {
+	if aerr, ok := err.(management.AzureError); ok {
+		switch aerr.Code {
+		case "ResourceNotFound":
+			return ErrStorageAccountNotFound
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+// bufferLogger collects Info output for inspection
+type bufferLogger struct {
+	bytes.Buffer
+}
+
+func (lg *bufferLogger) Info(format string, args ...interface{}) {
+	fmt.Fprintf(&lg.Buffer, format, args...)
+}
+
+func (lg *bufferLogger) Debug(format string, args ...interface{}) {}
+
+// A stand-in for the service management API, serving one storage account
+func managementServer(c *C) *httptest.Server {
+	const service = `<StorageService xmlns="http://schemas.microsoft.com/windowsazure">
+  <Url>https://management.core.windows.net/sub/services/storageservices/acct</Url>
+  <ServiceName>acct</ServiceName>
+  <StorageServiceProperties>
+    <Location>West US</Location>
+    <Status>Created</Status>
+    <Endpoints>
+      <Endpoint>https://acct.blob.core.chinacloudapi.cn/</Endpoint>
+      <Endpoint>https://acct.queue.core.chinacloudapi.cn/</Endpoint>
+    </Endpoints>
+  </StorageServiceProperties>
+</StorageService>`
+
+	keys := func(primary string) string {
+		return `<StorageService xmlns="http://schemas.microsoft.com/windowsazure">
+  <StorageServiceKeys><Primary>` + primary + `</Primary><Secondary>c2Vjb25kYXJ5</Secondary></StorageServiceKeys>
+</StorageService>`
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/sub/services/storageservices":
+			fmt.Fprintf(w, `<StorageServices xmlns="http://schemas.microsoft.com/windowsazure">%s</StorageServices>`, service)
+		case r.URL.Path == "/sub/services/storageservices/acct":
+			fmt.Fprint(w, service)
+		case r.URL.Path == "/sub/services/storageservices/acct/keys" && r.Method == "GET":
+			fmt.Fprint(w, keys("cHJpbWFyeQ=="))
+		case r.URL.Path == "/sub/services/storageservices/acct/keys" && r.Method == "POST":
+			c.Check(r.URL.Query().Get("action"), Equals, "regenerate")
+			fmt.Fprint(w, keys("bmV3"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error xmlns="http://schemas.microsoft.com/windowsazure"><Code>ResourceNotFound</Code><Message>not found</Message></Error>`)
+		}
+	}))
+}
+
+func (s *S) TestAccounts(c *C) {
+	server := managementServer(c)
+	defer server.Close()
+
+	cfg := &AzbConfig{
+		SubscriptionID:        "sub",
+		ManagementCertificate: []byte("unused"),
+		ManagementURL:         server.URL,
+	}
+
+	run := func(cmd *AccountsCommand) (string, error) {
+		lg := &bufferLogger{}
+		cmd.SetConfig(cfg)
+		cmd.SetLogger(lg)
+		err := cmd.Dispatch(context.Background())
+		return lg.String(), err
+	}
+
+	out, err := run(&AccountsCommand{Subcommand: "list"})
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "acct\n")
+
+	out, err = run(&AccountsCommand{Subcommand: "keys", Account: "acct"})
+	c.Assert(err, IsNil)
+	c.Assert(out, Matches, "(?s)primary   cHJpbWFyeQ==\n.*")
+
+	_, err = run(&AccountsCommand{Subcommand: "show", Account: "nope"})
+	c.Assert(err, Equals, ErrStorageAccountNotFound)
+
+	// Regenerating is destructive, so needs -f
+	out, err = run(&AccountsCommand{Subcommand: "regenerate", Account: "acct", KeyType: "primary"})
+	c.Assert(err, IsNil)
+	c.Assert(out, Matches, "Would regenerate.*\n")
+
+	cmd := &AccountsCommand{Subcommand: "regenerate", Account: "acct", KeyType: "primary"}
+	cmd.SetDestructive(true)
+	out, err = run(cmd)
+	c.Assert(err, IsNil)
+	c.Assert(out, Matches, "(?s)primary   bmV3\n.*")
+
+	path := filepath.Join(c.MkDir(), "azb.toml")
+	_, err = run(&AccountsCommand{Subcommand: "configure", Account: "acct", Target: "china", ConfigFile: path})
+	c.Assert(err, IsNil)
+
+	written, err := GetConfig(path, "china")
+	c.Assert(err, IsNil)
+	c.Assert(written.Name, Equals, "acct")
+	c.Assert(written.AccessKey, Equals, "cHJpbWFyeQ==")
+	c.Assert(written.BlobEndpoint, Equals, "core.chinacloudapi.cn")
+	c.Assert(written.UseHTTPS, Equals, true)
+}
@@ -1,7 +1,9 @@
 package lib
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/management"
@@ -13,10 +15,13 @@ var (
 	ErrUnrecognizedCommand     = errors.New("unrecognized command")
 	ErrContainerNotFound       = errors.New("container not found")
 	ErrContainerOrBlobNotFound = errors.New("container or blob not found")
+	ErrInterrupted             = errors.New("interrupted")
 )
 
 type Command interface {
-	Dispatch() error
+	// Dispatch runs the command.  If ctx is cancelled, it stops early,
+	// reports what it finished and returns ErrInterrupted.
+	Dispatch(ctx context.Context) error
 	SetConfig(cfg *AzbConfig)
 	Config() *AzbConfig
 	AddSource(blob *BlobSpec)
@@ -31,8 +36,26 @@ type Command interface {
 }
 
 type SimpleCommand struct {
+	ctx         context.Context
 	config      *AzbConfig
 	Command     string
+	Recursive   bool     // ls, get, rm: every blob under the path, not just one level
+	LongFormat  bool     // ls: show properties alongside names
+	HumanSizes  bool     // ls, tree: show sizes in KB, MB, etc.
+	SortBy      string   // ls, tree: name, size or time
+	Reverse     bool     // ls, tree: reverse the sort order
+	DirsFirst   bool     // tree: list directories ahead of blobs
+	Depth       int      // tree: levels to show, or 0 for all
+	BlobSizes   bool     // tree: show the size of each blob
+	DiskUsage   bool     // tree: show the total size and count of blobs under each directory
+	DirsOnly    bool     // tree: leave out blobs, showing only directories
+	Charset     string   // tree: draw lines in ascii or unicode
+	Color       bool     // tree: color directories and large blobs
+	Expression  []string // find: predicates and actions, as for find(1)
+	MaxResults  int      // ls: stop after this many entries
+	Marker      string   // ls: resume a listing where an earlier one stopped
+	Format      string   // ls, tree, get: csv, tsv, ndjson, yaml or a template for each record
+	Range       string   // get: only the bytes start-end of the blob, or start- to its end
 	source      *BlobSpec
 	destination *BlobSpec
 	localPath   string
@@ -40,6 +63,8 @@ type SimpleCommand struct {
 	destructive bool
 	workers     int
 	logger      Logger
+	nextMarker  string // where a listing cut short by MaxResults left off
+	records     *recordWriter
 }
 
 // Command interface
@@ -55,7 +80,17 @@ func (cmd *SimpleCommand) SetWorkers(n int)          { cmd.workers = n }
 func (cmd *SimpleCommand) SetLogger(l Logger)        { cmd.logger = l }
 func (cmd *SimpleCommand) Logger() Logger            { return cmd.logger }
 
-func (cmd *SimpleCommand) Dispatch() error {
+func (cmd *SimpleCommand) Dispatch(ctx context.Context) error {
+	cmd.ctx = ctx
+
+	if cmd.Format != "" {
+		w, err := newRecordWriter(cmd.Format, cmd.logger)
+		if err != nil {
+			return err
+		}
+		cmd.records = w
+	}
+
 	switch cmd.Command {
 	case "ls":
 		return cmd.ls()
@@ -67,6 +102,8 @@ func (cmd *SimpleCommand) Dispatch() error {
 		return cmd.rm()
 	case "put":
 		return cmd.put()
+	case "find":
+		return cmd.find()
 	default:
 		return ErrUnrecognizedCommand
 	}
@@ -89,6 +126,15 @@ func (cmd *SimpleCommand) rm() error {
 		return ErrUnrecognizedCommand
 	}
 
+	// A pattern may match any number of blobs
+	if cmd.source.Pattern != nil {
+		cmd.Recursive = true
+	}
+	if !cmd.Recursive && !cmd.source.Filter.IsZero() {
+		return ErrFilterNeedsRecursive
+	}
+	cmd.source.asDirectory(cmd.Recursive)
+
 	return cmd.rmBlob()
 }
 
@@ -97,10 +143,6 @@ func (cmd *SimpleCommand) tree() error {
 		return ErrUnrecognizedCommand
 	}
 
-	if cmd.source.PathPresent {
-		return ErrUnrecognizedCommand
-	}
-
 	return cmd.treeBlobs()
 }
 
@@ -109,6 +151,24 @@ func (cmd *SimpleCommand) pull() error {
 		return ErrUnrecognizedCommand
 	}
 
+	if cmd.source.Pattern != nil {
+		cmd.Recursive = true
+	}
+	if !cmd.Recursive && !cmd.source.Filter.IsZero() {
+		return ErrFilterNeedsRecursive
+	}
+	cmd.source.asDirectory(cmd.Recursive)
+
+	// A range is of one blob
+	if cmd.Range != "" {
+		if cmd.Recursive {
+			return ErrUnrecognizedCommand
+		}
+		if err := checkByteRange(cmd.Range); err != nil {
+			return err
+		}
+	}
+
 	return cmd.pullBlob()
 }
 
@@ -120,8 +180,17 @@ func (cmd *SimpleCommand) put() error {
 	return cmd.putBlob()
 }
 
+func (cfg *AzbConfig) getManagementClient() (management.Client, error) {
+	mc := management.DefaultConfig()
+	if cfg.ManagementURL != "" {
+		mc.ManagementURL = cfg.ManagementURL
+	}
+
+	return management.NewClientFromConfig(cfg.SubscriptionID, cfg.ManagementCertificate, mc)
+}
+
 func (cfg *AzbConfig) getStorageService() (*storageservice.StorageServiceClient, error) {
-	cli, err := management.NewClient(cfg.Name, cfg.ManagementCertificate)
+	cli, err := cfg.getManagementClient()
 	if err != nil {
 		return nil, err
 	}
@@ -131,19 +200,34 @@ func (cfg *AzbConfig) getStorageService() (*storageservice.StorageServiceClient,
 	return &stor, nil
 }
 
-func (cfg *AzbConfig) getBlobStorageClient() (*storage.BlobStorageClient, error) {
-	var res error
-	for i := 0; i < 3; i++ {
-		stor, err := storage.NewBasicClient(cfg.Name, cfg.AccessKey)
-		if err != nil {
-			res = err
-			continue
-		}
-		c := stor.GetBlobService()
-		return &c, nil
+// storageTransport carries every blob storage request.  Tests replace it to
+// talk to a local stand-in for the service.
+var storageTransport http.RoundTripper = http.DefaultTransport
+
+// getBlobStorageClient returns a client whose requests are abandoned when ctx
+// is cancelled
+func (cfg *AzbConfig) getBlobStorageClient(ctx context.Context) (*storage.BlobStorageClient, error) {
+	stor, err := storage.NewClient(cfg.Name, cfg.AccessKey, cfg.BlobEndpoint, cfg.APIVersion, cfg.UseHTTPS)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every call made through the client is retried according to the policy
+	stor.HTTPClient = &http.Client{
+		Transport: &retryTransport{ctx, &cfg.Retry, storageTransport},
+	}
+
+	c := stor.GetBlobService()
+	return &c, nil
+}
+
+// interrupted replaces the error of a call cut short by cancellation
+func interrupted(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ErrInterrupted
 	}
 
-	return nil, res
+	return err
 }
 
 func parseLastModified(s string) time.Time {
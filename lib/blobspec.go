@@ -2,6 +2,8 @@ package lib
 
 import (
 	"errors"
+	"regexp"
+	"regexp/syntax"
 	"strings"
 )
 
@@ -9,29 +11,167 @@ var (
 	ErrBadBlobSpec = errors.New("malformed blobspec")
 )
 
+// globChars are the characters that make a blob path a pattern
+const globChars = "*?["
+
 type BlobSpec struct {
 	Container   string
 	Path        string
 	PathPresent bool
+
+	// Pattern, if set, selects blobs by name.  Path then holds the longest
+	// literal prefix of the pattern, which the service can filter on.
+	Pattern *regexp.Regexp
+	pattern string // as given, for display
+
+	// Filter narrows listings by age and size
+	Filter BlobFilter
 }
 
+// ParseBlobSpec splits container/path.  A path containing *, ? or [ is a
+// shell-style glob: * and ? match within a directory level, ** matches
+// across levels, and [...] matches a character class.
 func ParseBlobSpec(s string) (*BlobSpec, error) {
 	if s == "" {
-		return &BlobSpec{"", "", false}, nil
+		return &BlobSpec{Container: "", Path: "", PathPresent: false}, nil
 	}
 
 	if i := strings.Index(s, "/"); i != -1 {
 		z := strings.SplitN(s, "/", 2)
-		return &BlobSpec{z[0], z[1], true}, nil
+		bs := &BlobSpec{Container: z[0], Path: z[1], PathPresent: true}
+
+		if i := strings.IndexAny(bs.Path, globChars); i != -1 {
+			re, err := globToRegexp(bs.Path)
+			if err != nil {
+				return nil, ErrBadBlobSpec
+			}
+			bs.Pattern, bs.pattern = re, bs.Path
+			bs.Path = bs.Path[:i]
+		}
+
+		return bs, nil
+	}
+
+	return &BlobSpec{Container: s, Path: "", PathPresent: false}, nil
+}
+
+// ParseBlobSpecRegex splits container/regex.  The regular expression must
+// match the whole blob name.  An empty one is no pattern at all, so c/ still
+// lists the whole container.
+func ParseBlobSpecRegex(s string) (*BlobSpec, error) {
+	z := strings.SplitN(s, "/", 2)
+	if len(z) < 2 || z[1] == "" {
+		return ParseBlobSpec(s)
 	}
 
-	return &BlobSpec{s, "", false}, nil
+	re, err := regexp.Compile("^(?:" + z[1] + ")$")
+	if err != nil {
+		return nil, ErrBadBlobSpec
+	}
+
+	bs := &BlobSpec{Container: z[0], PathPresent: true, Pattern: re, pattern: z[1]}
+	bs.Path = regexpLiteralPrefix(z[1])
+
+	return bs, nil
+}
+
+// regexpLiteralPrefix finds the text every match of expr must start with.
+// (regexp's own LiteralPrefix gives up on most unanchored expressions.)
+func regexpLiteralPrefix(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+
+	var prefix []rune
+	for _, sub := range subs {
+		switch {
+		case sub.Op == syntax.OpBeginText:
+		case sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0:
+			prefix = append(prefix, sub.Rune...)
+		default:
+			return string(prefix)
+		}
+	}
+
+	return string(prefix)
+}
+
+// asDirectory makes the path of a recursive spec without a pattern name a
+// directory, so logs means logs/... and not logs-archive or logs.txt too
+func (x *BlobSpec) asDirectory(recursive bool) {
+	if recursive && x.Pattern == nil && x.Path != "" && !strings.HasSuffix(x.Path, "/") {
+		x.Path += "/"
+	}
+}
+
+// Matches reports whether the blob name is selected by the spec's pattern.
+// Without a pattern, every blob under the path matches.
+func (x *BlobSpec) Matches(name string) bool {
+	if x.Pattern == nil {
+		return strings.HasPrefix(name, x.Path)
+	}
+
+	return x.Pattern.MatchString(name)
+}
+
+// Selects reports whether a listed blob matches the spec and its filter
+func (x *BlobSpec) Selects(b *blob) bool {
+	return x.Matches(b.Name) && x.Filter.Matches(b)
 }
 
 func (x *BlobSpec) String() string {
 	str := x.Container
-	if x.PathPresent {
+	if x.pattern != "" {
+		str = str + "/" + x.pattern
+	} else if x.PathPresent {
 		str = str + "/" + x.Path
 	}
 	return str
 }
+
+// globToRegexp translates a shell-style glob into an equivalent regexp
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch ch := glob[i]; ch {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				// any number of directory levels, including none
+				b.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, ErrBadBlobSpec
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
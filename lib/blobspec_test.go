@@ -54,3 +54,78 @@ func (s *S) TestBlobSpec(c *C) {
 	c.Assert(bs.Path, Equals, "bar")
 	c.Assert(bs.Container, Equals, "foo")
 }
+
+func (s *S) TestBlobSpecGlob(c *C) {
+	bs, err := ParseBlobSpec("logs/2016-*/app*.gz")
+	c.Assert(err, IsNil)
+	c.Assert(bs.Container, Equals, "logs")
+	c.Assert(bs.Path, Equals, "2016-")
+	c.Assert(bs.String(), Equals, "logs/2016-*/app*.gz")
+	c.Assert(bs.Matches("2016-05/app1.gz"), Equals, true)
+	c.Assert(bs.Matches("2016-05/old/app1.gz"), Equals, false)
+	c.Assert(bs.Matches("2016-05/app1.gz.bak"), Equals, false)
+
+	bs, err = ParseBlobSpec("logs/**/*.gz")
+	c.Assert(err, IsNil)
+	c.Assert(bs.Path, Equals, "")
+	c.Assert(bs.Matches("a.gz"), Equals, true)
+	c.Assert(bs.Matches("2016/05/a.gz"), Equals, true)
+	c.Assert(bs.Matches("2016/05/a.txt"), Equals, false)
+
+	bs, err = ParseBlobSpec("logs/app?.[!0-4]")
+	c.Assert(err, IsNil)
+	c.Assert(bs.Path, Equals, "app")
+	c.Assert(bs.Matches("app1.7"), Equals, true)
+	c.Assert(bs.Matches("app1.3"), Equals, false)
+	c.Assert(bs.Matches("app/.7"), Equals, false)
+
+	// Without a pattern, everything under the path matches
+	bs, err = ParseBlobSpec("logs/2016")
+	c.Assert(err, IsNil)
+	c.Assert(bs.Pattern, IsNil)
+	c.Assert(bs.Matches("2016/a.gz"), Equals, true)
+
+	_, err = ParseBlobSpec("logs/app[.gz")
+	c.Assert(err, Equals, ErrBadBlobSpec)
+}
+
+func (s *S) TestBlobSpecRegex(c *C) {
+	bs, err := ParseBlobSpecRegex(`logs/2016/app\d+\.gz`)
+	c.Assert(err, IsNil)
+	c.Assert(bs.Container, Equals, "logs")
+	c.Assert(bs.Path, Equals, "2016/app")
+	c.Assert(bs.String(), Equals, `logs/2016/app\d+\.gz`)
+	c.Assert(bs.Matches("2016/app12.gz"), Equals, true)
+	c.Assert(bs.Matches("2016/app12.gz.bak"), Equals, false)
+
+	bs, err = ParseBlobSpecRegex("logs")
+	c.Assert(err, IsNil)
+	c.Assert(bs.PathPresent, Equals, false)
+
+	// An empty expression matches everything, as the glob form does
+	bs, err = ParseBlobSpecRegex("logs/")
+	c.Assert(err, IsNil)
+	c.Assert(bs.PathPresent, Equals, true)
+	c.Assert(bs.Pattern, IsNil)
+	c.Assert(bs.Matches("2016/app12.gz"), Equals, true)
+
+	_, err = ParseBlobSpecRegex("logs/(")
+	c.Assert(err, Equals, ErrBadBlobSpec)
+}
+
+func (s *S) TestBlobSpecAsDirectory(c *C) {
+	for spec, path := range map[string]string{"c/logs": "logs/", "c/logs/": "logs/", "c/": "", "c/logs*": "logs"} {
+		bs, _ := ParseBlobSpec(spec)
+		bs.asDirectory(true)
+		c.Assert(bs.Path, Equals, path, Commentf("spec %s", spec))
+	}
+
+	bs, _ := ParseBlobSpec("c/logs")
+	bs.asDirectory(false)
+	c.Assert(bs.Path, Equals, "logs")
+	c.Assert(bs.Matches("logs.txt"), Equals, true)
+	bs.asDirectory(true)
+	c.Assert(bs.Matches("logs.txt"), Equals, false)
+	c.Assert(bs.Matches("logs-archive/a"), Equals, false)
+	c.Assert(bs.Matches("logs/a"), Equals, true)
+}
@@ -0,0 +1,260 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+// catChunkSize is how much of a blob head and tail read at a time, looking
+// for line ends
+const catChunkSize = 64 * 1024
+
+// CatCommand prints blobs to standard output: cat all of each blob, head
+// the start of each and tail the end.  head and tail read only the ranges
+// they need, so the last lines of a large log take a request or two.
+type CatCommand struct {
+	Command    string // cat, head or tail
+	Lines      int    // head, tail: the number of lines to print
+	Bytes      int64  // head, tail: the number of bytes to print, instead of lines
+	config     *AzbConfig
+	sources    []*BlobSpec
+	outputMode string
+	logger     Logger
+	out        io.Writer
+	ctx        context.Context
+}
+
+// Command interface
+func (cmd *CatCommand) SetConfig(cfg *AzbConfig)  { cmd.config = cfg }
+func (cmd *CatCommand) Config() *AzbConfig        { return cmd.config }
+func (cmd *CatCommand) AddSource(blob *BlobSpec)  { cmd.sources = append(cmd.sources, blob) }
+func (cmd *CatCommand) SetDst(blob *BlobSpec)     {}
+func (cmd *CatCommand) SetLocalPath(path string)  {}
+func (cmd *CatCommand) SetOutputMode(mode string) { cmd.outputMode = mode }
+func (cmd *CatCommand) OutputMode() string        { return cmd.outputMode }
+func (cmd *CatCommand) SetDestructive(b bool)     {}
+func (cmd *CatCommand) SetWorkers(n int)          {}
+func (cmd *CatCommand) SetLogger(l Logger)        { cmd.logger = l }
+func (cmd *CatCommand) Logger() Logger            { return cmd.logger }
+
+// catBlob is a blob to print, and the container it's in
+type catBlob struct {
+	container string
+	*blob
+}
+
+func (cmd *CatCommand) Dispatch(ctx context.Context) error {
+	cmd.ctx = ctx
+
+	if len(cmd.sources) == 0 {
+		return ErrUnrecognizedCommand
+	}
+	switch cmd.Command {
+	case "cat", "head", "tail":
+	default:
+		return ErrUnrecognizedCommand
+	}
+
+	if cmd.out == nil {
+		cmd.out = os.Stdout
+	}
+
+	// get the client
+	client, err := cmd.config.getBlobStorageClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Find every blob first, so a missing one stops us before any output
+	arr := []*catBlob{}
+	for _, src := range cmd.sources {
+		found, err := cmd.findBlobs(client, src)
+		if err != nil {
+			return err
+		}
+		for _, b := range found {
+			arr = append(arr, &catBlob{src.Container, b})
+		}
+	}
+
+	for i, b := range arr {
+		// Like head(1) and tail(1), name each of several blobs
+		if cmd.Command != "cat" && len(arr) > 1 {
+			if i > 0 {
+				fmt.Fprintln(cmd.out)
+			}
+			fmt.Fprintf(cmd.out, "==> %s/%s <==\n", b.container, b.Name)
+		}
+
+		switch cmd.Command {
+		case "head":
+			err = cmd.head(client, b)
+		case "tail":
+			err = cmd.tail(client, b)
+		default:
+			_, err = downloadRange(ctx, client, b.container, b.Name, "", cmd.out)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findBlobs lists the blobs matching a source's pattern, or the one blob
+// at its path
+func (cmd *CatCommand) findBlobs(client *storage.BlobStorageClient, src *BlobSpec) ([]*blob, error) {
+	if src.Pattern != nil {
+		arr, err := listAllBlobs(client, src)
+		if err != nil {
+			return nil, handleListError(interrupted(cmd.ctx, err))
+		}
+		if len(arr) == 0 {
+			return nil, ErrContainerOrBlobNotFound
+		}
+		return arr, nil
+	}
+
+	// The blob itself is listed ahead of any others its name is a prefix of
+	params := storage.ListBlobsParameters{Prefix: src.Path, MaxResults: 1}
+	res, err := client.ListBlobs(src.Container, params)
+	if err != nil {
+		return nil, handleListError(interrupted(cmd.ctx, err))
+	}
+	if len(res.Blobs) == 0 || res.Blobs[0].Name != src.Path {
+		return nil, ErrContainerOrBlobNotFound
+	}
+
+	return []*blob{newBlob(res.Blobs[0])}, nil
+}
+
+// head prints the first Bytes, or Lines, of a blob
+func (cmd *CatCommand) head(client *storage.BlobStorageClient, b *catBlob) error {
+	size := b.ContentLength
+	if cmd.Bytes > 0 {
+		if cmd.Bytes < size {
+			size = cmd.Bytes
+		}
+		return cmd.copyRange(client, b, 0, size)
+	}
+
+	lines := 0
+	for start := int64(0); start < size && lines < cmd.Lines; start += catChunkSize {
+		chunk, err := cmd.readRange(client, b, start, start+catChunkSize)
+		if err != nil {
+			return err
+		}
+
+		// stop after the last line end wanted
+		for i, c := range chunk {
+			if c == '\n' {
+				lines++
+				if lines == cmd.Lines {
+					chunk = chunk[:i+1]
+					break
+				}
+			}
+		}
+
+		if _, err := cmd.out.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tail prints the last Bytes, or Lines, of a blob, reading back from its
+// end only as far as it must
+func (cmd *CatCommand) tail(client *storage.BlobStorageClient, b *catBlob) error {
+	size := b.ContentLength
+	if cmd.Bytes > 0 {
+		start := size - cmd.Bytes
+		if start < 0 {
+			start = 0
+		}
+		return cmd.copyRange(client, b, start, size)
+	}
+
+	if cmd.Lines <= 0 {
+		return nil
+	}
+
+	text := []byte{}
+	for end := size; end > 0; {
+		start := end - catChunkSize
+		if start < 0 {
+			start = 0
+		}
+
+		chunk, err := cmd.readRange(client, b, start, end)
+		if err != nil {
+			return err
+		}
+		text = append(chunk, text...)
+		end = start
+
+		if i := tailStart(text, cmd.Lines); i >= 0 {
+			text = text[i:]
+			break
+		}
+	}
+
+	_, err := cmd.out.Write(text)
+	return err
+}
+
+// tailStart finds where the last n lines of text begin, or -1 if text
+// holds fewer.  A line end at the very end doesn't begin another line.
+func tailStart(text []byte, n int) int {
+	i := len(text)
+	if i > 0 && text[i-1] == '\n' {
+		i--
+	}
+
+	for ; n > 0; n-- {
+		j := bytes.LastIndexByte(text[:i], '\n')
+		if j < 0 {
+			return -1
+		}
+		i = j
+	}
+
+	return i + 1
+}
+
+// copyRange prints the bytes of a blob from start up to end
+func (cmd *CatCommand) copyRange(client *storage.BlobStorageClient, b *catBlob, start, end int64) error {
+	if start >= end {
+		return nil
+	}
+
+	rng := fmt.Sprintf("%d-%d", start, end-1)
+	_, err := downloadRange(cmd.ctx, client, b.container, b.Name, rng, cmd.out)
+	return err
+}
+
+// readRange reads the bytes of a blob from start up to end, or the end of
+// the blob
+func (cmd *CatCommand) readRange(client *storage.BlobStorageClient, b *catBlob, start, end int64) ([]byte, error) {
+	if end > b.ContentLength {
+		end = b.ContentLength
+	}
+	if start >= end {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	rng := fmt.Sprintf("%d-%d", start, end-1)
+	if _, err := downloadRange(cmd.ctx, client, b.container, b.Name, rng, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestCat(c *C) {
+	var long bytes.Buffer
+	for i := 1; i <= 20000; i++ {
+		fmt.Fprintf(&long, "line %05d\n", i)
+	}
+	size := int64(long.Len())
+
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {
+			testBlob("a.txt", 7),
+			testBlob("app.log", size),
+			testBlob("b.txt", 11),
+		},
+	})
+	svc.contents = map[string]string{
+		"logs/a.txt":   "one\ntwo",
+		"logs/app.log": long.String(),
+		"logs/b.txt":   "three\nfour\n",
+	}
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	// cat writes blobs to its own output, rather than logging them
+	run := func(cmd *CatCommand, specs ...string) (string, error) {
+		var out bytes.Buffer
+		cmd.out = &out
+		svc.readRanges()
+		_, err := runCommand(c, context.Background(), cfg, cmd, "", specs...)
+		return out.String(), err
+	}
+
+	out, err := run(&CatCommand{Command: "cat"}, "logs/b.txt", "logs/a.txt")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "three\nfour\none\ntwo")
+
+	// head and tail read only the ranges they need
+	out, err = run(&CatCommand{Command: "head", Lines: 2}, "logs/app.log")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "line 00001\nline 00002\n")
+	c.Assert(svc.readRanges(), DeepEquals, []string{"bytes=0-65535"})
+
+	out, err = run(&CatCommand{Command: "tail", Lines: 3}, "logs/app.log")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "line 19998\nline 19999\nline 20000\n")
+	c.Assert(svc.readRanges(), DeepEquals, []string{fmt.Sprintf("bytes=%d-%d", size-catChunkSize, size-1)})
+
+	out, err = run(&CatCommand{Command: "tail", Bytes: 6}, "logs/app.log")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "20000\n")
+	c.Assert(svc.readRanges(), DeepEquals, []string{fmt.Sprintf("bytes=%d-%d", size-6, size-1)})
+
+	// tail reads back a chunk at a time for longer tails
+	out, err = run(&CatCommand{Command: "tail", Lines: 7000}, "logs/app.log")
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(out, "line 13001\n"), Equals, true)
+	c.Assert(int64(len(out)), Equals, int64(7000*11))
+	c.Assert(svc.readRanges(), HasLen, 2)
+
+	// Several blobs are each named
+	out, err = run(&CatCommand{Command: "head", Lines: 1}, "logs/*.txt")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "==> logs/a.txt <==\none\n\n==> logs/b.txt <==\nthree\n")
+
+	out, err = run(&CatCommand{Command: "tail", Lines: 5}, "logs/a.txt")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "one\ntwo")
+
+	out, err = run(&CatCommand{Command: "cat"}, "logs/a.txt", "logs/a")
+	c.Assert(err, Equals, ErrContainerOrBlobNotFound)
+	c.Assert(out, Equals, "")
+}
+
+func (s *S) TestGetRange(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{"logs": {testBlob("a.txt", 10)}})
+	svc.contents = map[string]string{"logs/a.txt": "0123456789"}
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	dst := filepath.Join(c.MkDir(), "a.txt")
+	cmd := &SimpleCommand{Command: "get", Range: "2-4"}
+	cmd.SetLocalPath(dst)
+	runSimple(c, cfg, cmd, "logs/a.txt", "")
+
+	b, err := ioutil.ReadFile(dst)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "234")
+
+	for _, rng := range []string{"0-0", "5-", "10-20"} {
+		c.Assert(checkByteRange(rng), IsNil)
+	}
+	for _, rng := range []string{"", "5", "-5", "4-2", "a-b", "1-x"} {
+		c.Assert(checkByteRange(rng), Equals, ErrBadRange, Commentf("range %q", rng))
+	}
+}
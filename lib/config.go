@@ -1,52 +1,707 @@
 package lib
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/BurntSushi/toml"
 )
 
 var (
 	ErrEnvironmentNotFound = errors.New("undefined environment")
+	ErrEnvironmentExists   = errors.New("environment already defined")
+	ErrUnknownConfigKey    = errors.New("unknown configuration key")
 )
 
+const (
+	DefaultEnvironment = "default"
+
+	// Prefix of the environment variables that override config file settings,
+	// e.g. AZB_STORAGE_ACCOUNT_NAME overrides storage_account_name.
+	envVarPrefix = "AZB_"
+
+	localConfigName = ".azb.toml"
+)
+
+// Location of the machine-wide config file.  Overridden on Windows.
+var systemConfigFile = "/usr/local/etc/.azb.toml"
+
+// The keys understood in each environment section, in display order.
+var configKeys = []string{
+	"storage_account_name",
+	"storage_account_access_key",
+	"subscription_id",
+	"management_certificate",
+	"management_url",
+	"blob_endpoint",
+	"api_version",
+	"protocol",
+	"use_emulator",
+	"retry_max_attempts",
+	"retry_base_delay",
+	"retry_max_delay",
+	"retry_jitter",
+	"retry_status_codes",
+	"retry_network_errors",
+	"price_file",
+	"price_hot",
+	"price_cool",
+	"price_archive",
+}
+
+// Keys whose values are masked when displayed
+var secretConfigKeys = map[string]bool{
+	"storage_account_access_key": true,
+}
+
+// Keys stored as TOML booleans rather than strings
+var boolConfigKeys = map[string]bool{
+	"use_emulator":         true,
+	"retry_jitter":         true,
+	"retry_network_errors": true,
+}
+
+// Keys stored as TOML integers, and arrays of integers
+var intConfigKeys = map[string]bool{
+	"retry_max_attempts": true,
+}
+var intListConfigKeys = map[string]bool{
+	"retry_status_codes": true,
+}
+
+// Keys stored as TOML floats
+var floatConfigKeys = map[string]bool{
+	"price_hot":     true,
+	"price_cool":    true,
+	"price_archive": true,
+}
+
 type AzbConfig struct {
 	Name                  string
 	AccessKey             string
+	SubscriptionID        string
 	ManagementCertificate []byte
+	ManagementURL         string
+
+	// Endpoint settings.  Defaults target the Azure public cloud over HTTPS.
+	BlobEndpoint string // base domain of the storage service, e.g. core.windows.net
+	APIVersion   string
+	UseHTTPS     bool
+	Emulator     bool // talk to a local storage emulator (Azurite) on 127.0.0.1:10000
+
+	Retry RetryPolicy
+
+	// Storage prices per GB-month, for the cost command
+	Prices StoragePrices
 }
 
-func GetConfig(configFile, environment string) (*AzbConfig, error) {
+// EnvConfig is the effective configuration of one environment after merging
+// every layer, along with the source each setting was taken from.
+type EnvConfig struct {
+	Environment string
+	Values      map[string]interface{}
+	Sources     map[string]string
+	SearchPath  []string
+}
 
-	type envInfo struct {
-		Name                      string `toml:"storage_account_name"`
-		AccessKey                 string `toml:"storage_account_access_key"`
-		ManagementCertificatePath string `toml:"management_certificate"`
+// GetConfig loads the named environment.  Settings are resolved from, in order
+// of precedence:
+//
+//   - AZB_* environment variables (e.g. AZB_STORAGE_ACCOUNT_NAME), which only
+//     apply to $AZB_ENVIRONMENT, or "default" when it is unset
+//   - .azb.toml in the working directory or the nearest parent containing one
+//   - the user's config file ($XDG_CONFIG_HOME/azb/config.toml)
+//   - the system config file (/usr/local/etc/.azb.toml or C:\_azb.toml)
+//
+// If configFile is set, it replaces the file search path.  If environment is
+// empty, $AZB_ENVIRONMENT or "default" is used.  Each file looks like:
+//
+//	[default]
+//	storage_account_name = "myaccount"
+//	storage_account_access_key = "..."
+//	blob_endpoint = "core.chinacloudapi.cn"  # optional, sovereign clouds
+//	api_version = "2015-02-21"               # optional
+//	protocol = "https"                       # optional, http or https
+//
+//	# Only needed by the accounts commands
+//	subscription_id = "..."
+//	management_certificate = "/path/to/cert.pem"
+//	management_url = "https://management.core.windows.net"  # optional
+//
+//	# Optional retry policy for blob storage calls.  The accounts commands
+//	# go through the management client, which keeps its own.  Defaults shown.
+//	retry_max_attempts = 4
+//	retry_base_delay = "500ms"
+//	retry_max_delay = "30s"
+//	retry_jitter = true
+//	retry_status_codes = [408, 429, 500, 502, 503, 504]
+//	retry_network_errors = true
+//
+//	# Only needed by the cost command: storage prices per GB-month by tier,
+//	# given directly or in a price file of the form hot = 0.0184 etc.
+//	price_file = "/path/to/prices.toml"
+//	price_hot = 0.0184
+//	price_cool = 0.01
+//	price_archive = 0.002
+//
+//	[local]
+//	use_emulator = true                      # Azurite on 127.0.0.1:10000
+func GetConfig(configFile, environment string) (*AzbConfig, error) {
+	env, err := ResolveConfig(configFile, environment)
+	if err != nil {
+		return nil, err
 	}
 
-	var config map[string]envInfo
-	if _, err := toml.DecodeFile(configFile, &config); err != nil {
+	return env.AzbConfig()
+}
+
+// GetManagementConfig loads an environment used to manage the subscription
+// rather than a single storage account.  See GetConfig.
+func GetManagementConfig(configFile, environment string) (*AzbConfig, error) {
+	env, err := ResolveConfig(configFile, environment)
+	if err != nil {
 		return nil, err
 	}
 
-	env, ok := config[environment]
-	if !ok {
+	return env.ManagementConfig()
+}
+
+// ConfigSearchPath returns the config files consulted, highest precedence first.
+func ConfigSearchPath(configFile string) []string {
+	if configFile != "" {
+		return []string{configFile}
+	}
+
+	var paths []string
+	if local := findLocalConfig(); local != "" {
+		paths = append(paths, local)
+	}
+
+	if user := userConfigFile(); user != "" {
+		paths = append(paths, user)
+	}
+
+	return append(paths, systemConfigFile)
+}
+
+// ResolveConfig merges every configuration layer for an environment
+func ResolveConfig(configFile, environment string) (*EnvConfig, error) {
+	// AZB_* variables are meant for $AZB_ENVIRONMENT, or the default
+	meant := os.Getenv(envVarPrefix + "ENVIRONMENT")
+	if meant == "" {
+		meant = DefaultEnvironment
+	}
+	if environment == "" {
+		environment = meant
+	}
+
+	env := &EnvConfig{
+		Environment: environment,
+		Values:      map[string]interface{}{},
+		Sources:     map[string]string{},
+		SearchPath:  ConfigSearchPath(configFile),
+	}
+
+	found := false
+
+	// Environment variables win over every file, but only for the
+	// environment they're meant for.  Another is left to its files, and a
+	// mistyped -e still isn't found.
+	for _, key := range configKeys {
+		if environment != meant {
+			break
+		}
+		if v, ok := os.LookupEnv(envVarName(key)); ok {
+			env.Values[key] = v
+			env.Sources[key] = "$" + envVarName(key)
+			found = true
+		}
+	}
+
+	for _, path := range env.SearchPath {
+		config, err := readConfigFile(path)
+		if err != nil {
+			// Only a file the user asked for by name has to exist
+			if os.IsNotExist(err) && configFile == "" {
+				continue
+			}
+			return nil, err
+		}
+
+		section, ok := config[environment]
+		if !ok {
+			continue
+		}
+
+		found = true
+		for key, v := range section {
+			if _, set := env.Values[key]; !set {
+				env.Values[key] = v
+				env.Sources[key] = path
+			}
+		}
+	}
+
+	if !found {
 		return nil, ErrEnvironmentNotFound
 	}
 
-	if env.Name == "" || env.AccessKey == "" {
-		return nil, fmt.Errorf("Missing storage_account_name and/or storage_account_access_key for environment %s in file %s", environment, configFile)
+	return env, nil
+}
+
+// AzbConfig validates the merged settings and builds the client configuration
+func (env *EnvConfig) AzbConfig() (*AzbConfig, error) {
+	cfg, err := env.buildConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Name == "" || cfg.AccessKey == "" {
+		return nil, fmt.Errorf("Missing storage_account_name and/or storage_account_access_key for environment %s", env.Environment)
+	}
+
+	return cfg, nil
+}
+
+// ManagementConfig is like AzbConfig, but for environments used to manage the
+// subscription.  They need a management certificate, not a storage account.
+func (env *EnvConfig) ManagementConfig() (*AzbConfig, error) {
+	cfg, err := env.buildConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.SubscriptionID == "" || cfg.ManagementCertificate == nil {
+		return nil, fmt.Errorf("Missing subscription_id and/or management_certificate for environment %s", env.Environment)
+	}
+
+	return cfg, nil
+}
+
+func (env *EnvConfig) buildConfig() (*AzbConfig, error) {
+	emulator, err := env.boolean("use_emulator")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &AzbConfig{
+		Name:           env.str("storage_account_name"),
+		AccessKey:      env.str("storage_account_access_key"),
+		SubscriptionID: env.str("subscription_id"),
+		ManagementURL:  env.str("management_url"),
+		BlobEndpoint:   env.str("blob_endpoint"),
+		APIVersion:     env.str("api_version"),
+		UseHTTPS:       true,
+		Emulator:       emulator,
+	}
+
+	if cfg.Emulator {
+		// The emulator only ever serves its well-known development account
+		cfg.Name = storage.StorageEmulatorAccountName
+		cfg.AccessKey = storage.StorageEmulatorAccountKey
+		cfg.UseHTTPS = false
+	}
+
+	switch protocol := env.str("protocol"); protocol {
+	case "":
+	case "https":
+		cfg.UseHTTPS = true
+	case "http":
+		cfg.UseHTTPS = false
+	default:
+		return nil, fmt.Errorf("Invalid protocol %q for environment %s in %s (expected http or https)", protocol, env.Environment, env.Sources["protocol"])
 	}
 
-	if env.ManagementCertificatePath != "" {
-		buf, err := ioutil.ReadFile(env.ManagementCertificatePath)
+	if cfg.BlobEndpoint == "" {
+		cfg.BlobEndpoint = storage.DefaultBaseURL
+	}
+
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = storage.DefaultAPIVersion
+	}
+
+	if path := env.str("management_certificate"); path != "" {
+		buf, err := ioutil.ReadFile(path)
 		if err != nil {
 			return nil, err
 		}
-		return &AzbConfig{env.Name, env.AccessKey, buf}, nil
+		cfg.ManagementCertificate = buf
+	}
+
+	if cfg.Retry, err = env.retryPolicy(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Prices, err = env.prices(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// prices reads the price file, if any, then any prices set directly
+func (env *EnvConfig) prices() (StoragePrices, error) {
+	var p StoragePrices
+
+	var err error
+	if path := env.str("price_file"); path != "" {
+		if p, err = LoadPriceFile(path); err != nil {
+			return p, err
+		}
+	}
+
+	for key, price := range map[string]*float64{"price_hot": &p.Hot, "price_cool": &p.Cool, "price_archive": &p.Archive} {
+		if _, ok := env.Values[key]; ok {
+			if *price, err = env.float(key); err != nil {
+				return p, err
+			}
+		}
+	}
+
+	return p, nil
+}
+
+func (env *EnvConfig) retryPolicy() (RetryPolicy, error) {
+	p := DefaultRetryPolicy()
+
+	var err error
+	if _, ok := env.Values["retry_max_attempts"]; ok {
+		if p.MaxAttempts, err = env.integer("retry_max_attempts"); err != nil {
+			return p, err
+		}
+		if p.MaxAttempts < 1 {
+			return p, fmt.Errorf("Invalid value %d for retry_max_attempts in %s (expected at least 1)", p.MaxAttempts, env.Sources["retry_max_attempts"])
+		}
+	}
+
+	if _, ok := env.Values["retry_base_delay"]; ok {
+		if p.BaseDelay, err = env.duration("retry_base_delay"); err != nil {
+			return p, err
+		}
+	}
+
+	if _, ok := env.Values["retry_max_delay"]; ok {
+		if p.MaxDelay, err = env.duration("retry_max_delay"); err != nil {
+			return p, err
+		}
+	}
+
+	if _, ok := env.Values["retry_jitter"]; ok {
+		if p.Jitter, err = env.boolean("retry_jitter"); err != nil {
+			return p, err
+		}
+	}
+
+	if _, ok := env.Values["retry_status_codes"]; ok {
+		if p.StatusCodes, err = env.intList("retry_status_codes"); err != nil {
+			return p, err
+		}
+	}
+
+	if _, ok := env.Values["retry_network_errors"]; ok {
+		if p.NetworkErrors, err = env.boolean("retry_network_errors"); err != nil {
+			return p, err
+		}
+	}
+
+	return p, nil
+}
+
+// BlobServiceURL is the base URL of the blob service cfg talks to
+func (cfg *AzbConfig) BlobServiceURL() string {
+	scheme := "http"
+	if cfg.UseHTTPS {
+		scheme = "https"
+	}
+
+	if cfg.Emulator {
+		return fmt.Sprintf("%s://127.0.0.1:10000/%s", scheme, cfg.Name)
+	}
+
+	return fmt.Sprintf("%s://%s.blob.%s", scheme, cfg.Name, cfg.BlobEndpoint)
+}
+
+// Display returns a setting formatted for output, with secrets masked
+func (env *EnvConfig) Display(key string) string {
+	v := env.str(key)
+	if secretConfigKeys[key] {
+		return maskSecret(v)
+	}
+
+	return v
+}
+
+func (env *EnvConfig) str(key string) string {
+	v, ok := env.Values[key]
+	if !ok {
+		return ""
+	}
+
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+func (env *EnvConfig) boolean(key string) (bool, error) {
+	switch v := env.Values[key].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("Invalid value %q for %s in %s (expected true or false)", v, key, env.Sources[key])
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("Invalid value %v for %s in %s (expected true or false)", v, key, env.Sources[key])
+	}
+}
+
+// ParseConfigValue converts a setting given on the command line to the type
+// GetConfig expects to decode for key.
+func ParseConfigValue(key, value string) (interface{}, error) {
+	known := false
+	for _, k := range configKeys {
+		known = known || k == key
+	}
+	if !known {
+		return nil, ErrUnknownConfigKey
+	}
+
+	switch {
+	case boolConfigKeys[key]:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %q for %s (expected true or false)", value, key)
+		}
+		return b, nil
+	case intConfigKeys[key]:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %q for %s (expected an integer)", value, key)
+		}
+		return i, nil
+	case floatConfigKeys[key]:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %q for %s (expected a number)", value, key)
+		}
+		return f, nil
+	case intListConfigKeys[key]:
+		arr := []int64{}
+		for _, x := range strings.Split(value, ",") {
+			i, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid value %q for %s (expected comma-separated integers)", value, key)
+			}
+			arr = append(arr, i)
+		}
+		return arr, nil
+	}
+
+	return value, nil
+}
+
+// addEnvironment writes a new environment section.  It goes to configFile if
+// set, and otherwise to the user's config file.  Returns the file written.
+func addEnvironment(configFile, name string, section map[string]interface{}) (string, error) {
+	path := configFile
+	if path == "" {
+		path = userConfigFile()
+	}
+	if path == "" {
+		return "", fmt.Errorf("No configuration file to write to. Use -F to choose one")
+	}
+
+	config, err := readConfigFile(path)
+	if os.IsNotExist(err) {
+		config = map[string]map[string]interface{}{}
+	} else if err != nil {
+		return "", err
+	}
+
+	if _, ok := config[name]; ok {
+		return "", ErrEnvironmentExists
+	}
+
+	config[name] = section
+
+	return path, writeConfigFile(path, config)
+}
+
+func readConfigFile(path string) (map[string]map[string]interface{}, error) {
+	var config map[string]map[string]interface{}
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		config = map[string]map[string]interface{}{}
+	}
+
+	return config, nil
+}
+
+// writeConfigFile replaces path with config.  Any comments in the old file
+// are lost.  The file may hold access keys, so it is only readable by its
+// owner.
+func writeConfigFile(path string, config map[string]map[string]interface{}) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(config); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Write alongside the original and rename, so a failure can't leave a
+	// truncated config behind
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (env *EnvConfig) integer(key string) (int, error) {
+	switch v := env.Values[key].(type) {
+	case int64:
+		return int(v), nil
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid value %q for %s in %s (expected an integer)", v, key, env.Sources[key])
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("Invalid value %v for %s in %s (expected an integer)", v, key, env.Sources[key])
+	}
+}
+
+func (env *EnvConfig) float(key string) (float64, error) {
+	switch v := env.Values[key].(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid value %q for %s in %s (expected a number)", v, key, env.Sources[key])
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("Invalid value %v for %s in %s (expected a number)", v, key, env.Sources[key])
+	}
+}
+
+func (env *EnvConfig) duration(key string) (time.Duration, error) {
+	d, err := time.ParseDuration(env.str(key))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value %q for %s in %s (expected a duration such as 500ms)", env.str(key), key, env.Sources[key])
+	}
+
+	return d, nil
+}
+
+// intList reads a TOML array of integers, or a comma-separated string of them
+// when set from an environment variable
+func (env *EnvConfig) intList(key string) ([]int, error) {
+	bad := fmt.Errorf("Invalid value %v for %s in %s (expected a list of integers)", env.Values[key], key, env.Sources[key])
+
+	var arr []int
+	switch v := env.Values[key].(type) {
+	case []interface{}:
+		for _, x := range v {
+			i, ok := x.(int64)
+			if !ok {
+				return nil, bad
+			}
+			arr = append(arr, int(i))
+		}
+	case []int64:
+		for _, i := range v {
+			arr = append(arr, int(i))
+		}
+	case string:
+		for _, x := range strings.Split(v, ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(x))
+			if err != nil {
+				return nil, bad
+			}
+			arr = append(arr, i)
+		}
+	default:
+		return nil, bad
+	}
+
+	return arr, nil
+}
+
+func maskSecret(s string) string {
+	if len(s) <= 8 {
+		return strings.Repeat("*", len(s))
+	}
+
+	return strings.Repeat("*", 8) + s[len(s)-4:]
+}
+
+func envVarName(key string) string {
+	return envVarPrefix + strings.ToUpper(key)
+}
+
+// Walk up from the working directory looking for a project-local config
+func findLocalConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	for {
+		path := filepath.Join(dir, localConfigName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func userConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = userConfigDir()
+	}
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "azb", "config.toml")
+}
+
+// Base directory for per-user config files.  Overridden on Windows.
+var userConfigDir = func() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config")
 	}
 
-	return &AzbConfig{env.Name, env.AccessKey, nil}, nil
+	return ""
 }
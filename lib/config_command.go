@@ -0,0 +1,375 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+var (
+	ErrConfigTestFailed = errors.New("configuration test failed")
+)
+
+// ConfigCommand inspects and edits azb's own configuration.  Unlike the
+// storage commands, it runs without a resolved AzbConfig.
+type ConfigCommand struct {
+	Subcommand  string
+	ConfigFile  string
+	Environment string   // environment selected with -e
+	Target      string   // environment named on the command line
+	Key         string   // config set
+	Value       string   // config set
+	Settings    []string // config add, as key=value
+	config      *AzbConfig
+	outputMode  string
+	destructive bool
+	logger      Logger
+}
+
+// Command interface
+func (cmd *ConfigCommand) SetConfig(cfg *AzbConfig)  { cmd.config = cfg }
+func (cmd *ConfigCommand) Config() *AzbConfig        { return cmd.config }
+func (cmd *ConfigCommand) AddSource(blob *BlobSpec)  {}
+func (cmd *ConfigCommand) SetDst(blob *BlobSpec)     {}
+func (cmd *ConfigCommand) SetLocalPath(path string)  {}
+func (cmd *ConfigCommand) SetOutputMode(mode string) { cmd.outputMode = mode }
+func (cmd *ConfigCommand) OutputMode() string        { return cmd.outputMode }
+func (cmd *ConfigCommand) SetDestructive(b bool)     { cmd.destructive = b }
+func (cmd *ConfigCommand) SetWorkers(n int)          {}
+func (cmd *ConfigCommand) SetLogger(l Logger)        { cmd.logger = l }
+func (cmd *ConfigCommand) Logger() Logger            { return cmd.logger }
+
+func (cmd *ConfigCommand) Dispatch(ctx context.Context) error {
+	switch cmd.Subcommand {
+	case "show":
+		return cmd.show()
+	case "list":
+		return cmd.list()
+	case "add":
+		return cmd.add()
+	case "remove":
+		return cmd.remove()
+	case "set":
+		return cmd.set()
+	case "test":
+		return cmd.test(ctx)
+	default:
+		return ErrUnrecognizedCommand
+	}
+}
+
+type configSetting struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Source  string `json:"source"`
+	literal string // Value as it would be written in TOML
+}
+
+func (cmd *ConfigCommand) show() error {
+	env, err := ResolveConfig(cmd.ConfigFile, cmd.Environment)
+	if err != nil {
+		return err
+	}
+
+	settings := []*configSetting{}
+	for _, key := range configKeys {
+		v, ok := env.Values[key]
+		if !ok {
+			continue
+		}
+
+		s := &configSetting{Key: key, Value: env.Display(key), Source: env.Sources[key]}
+		s.literal = s.Value
+		if _, isString := v.(string); isString {
+			s.literal = strconv.Quote(s.Value)
+		}
+		settings = append(settings, s)
+	}
+
+	cmd.showReport(env, settings)
+
+	return nil
+}
+
+func (cmd *ConfigCommand) showReport(env *EnvConfig, settings []*configSetting) {
+	if cmd.outputMode == "json" {
+		tmp := struct {
+			Environment string           `json:"environment"`
+			SearchPath  []string         `json:"searchPath"`
+			Settings    []*configSetting `json:"settings"`
+		}{
+			Environment: env.Environment,
+			SearchPath:  append([]string{envVarPrefix + "*"}, env.SearchPath...),
+			Settings:    settings,
+		}
+
+		s, _ := json.Marshal(tmp)
+		cmd.logger.Info("%s\n", s)
+	} else {
+		cmd.logger.Info("# Search path, highest precedence first:\n")
+		cmd.logger.Info("#   %s*\n", envVarPrefix)
+		for _, path := range env.SearchPath {
+			cmd.logger.Info("#   %s\n", path)
+		}
+
+		cmd.logger.Info("[%s]\n", env.Environment)
+		for _, s := range settings {
+			cmd.logger.Info("%-44s # %s\n", fmt.Sprintf("%s = %s", s.Key, s.literal), s.Source)
+		}
+	}
+}
+
+type configEnvironment struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+func (cmd *ConfigCommand) list() error {
+	seen := map[string]bool{}
+	envs := []*configEnvironment{}
+
+	for _, path := range ConfigSearchPath(cmd.ConfigFile) {
+		config, err := readConfigFile(path)
+		if err != nil {
+			if os.IsNotExist(err) && cmd.ConfigFile == "" {
+				continue
+			}
+			return err
+		}
+
+		names := []string{}
+		for name := range config {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		// Files earlier in the search path shadow later ones
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				envs = append(envs, &configEnvironment{name, path})
+			}
+		}
+	}
+
+	if cmd.outputMode == "json" {
+		tmp := struct {
+			Environments []*configEnvironment `json:"environments"`
+		}{
+			Environments: envs,
+		}
+
+		s, _ := json.Marshal(tmp)
+		cmd.logger.Info("%s\n", s)
+	} else {
+		for _, e := range envs {
+			cmd.logger.Info("%-20s %s\n", e.Name, e.Source)
+		}
+		cmd.logger.Debug("Found %d environments\n", len(envs))
+	}
+
+	return nil
+}
+
+func (cmd *ConfigCommand) add() error {
+	section := map[string]interface{}{}
+	for _, setting := range cmd.Settings {
+		z := strings.SplitN(setting, "=", 2)
+		if len(z) != 2 {
+			return fmt.Errorf("Expected key=value, got %q", setting)
+		}
+
+		v, err := ParseConfigValue(z[0], z[1])
+		if err != nil {
+			return fmt.Errorf("%s: %s", z[0], err)
+		}
+		section[z[0]] = v
+	}
+
+	path, err := addEnvironment(cmd.ConfigFile, cmd.Target, section)
+	if err != nil {
+		return err
+	}
+
+	cmd.logger.Info("Added environment %s to %s\n", cmd.Target, path)
+	cmd.warnIncomplete()
+
+	return nil
+}
+
+func (cmd *ConfigCommand) remove() error {
+	path, config, err := cmd.findEnvironment()
+	if err != nil {
+		return err
+	}
+
+	if cmd.destructive == false {
+		cmd.logger.Info("Would remove environment %s from %s\n", cmd.Target, path)
+		return nil
+	}
+
+	delete(config, cmd.Target)
+	if err := writeConfigFile(path, config); err != nil {
+		return err
+	}
+
+	cmd.logger.Info("Removed environment %s from %s\n", cmd.Target, path)
+
+	return nil
+}
+
+func (cmd *ConfigCommand) set() error {
+	v, err := ParseConfigValue(cmd.Key, cmd.Value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", cmd.Key, err)
+	}
+
+	path, config, err := cmd.findEnvironment()
+	if err != nil {
+		return err
+	}
+
+	if config[cmd.Target] == nil {
+		config[cmd.Target] = map[string]interface{}{}
+	}
+	config[cmd.Target][cmd.Key] = v
+	if err := writeConfigFile(path, config); err != nil {
+		return err
+	}
+
+	cmd.logger.Info("Set %s in environment %s of %s\n", cmd.Key, cmd.Target, path)
+	cmd.warnIncomplete()
+
+	return nil
+}
+
+// findEnvironment loads the highest precedence config file that defines the
+// target environment
+func (cmd *ConfigCommand) findEnvironment() (string, map[string]map[string]interface{}, error) {
+	for _, path := range ConfigSearchPath(cmd.ConfigFile) {
+		config, err := readConfigFile(path)
+		if err != nil {
+			if os.IsNotExist(err) && cmd.ConfigFile == "" {
+				continue
+			}
+			return "", nil, err
+		}
+
+		if _, ok := config[cmd.Target]; ok {
+			return path, config, nil
+		}
+	}
+
+	return "", nil, ErrEnvironmentNotFound
+}
+
+// Point out an environment that was saved but can't be used yet, e.g. one
+// that is still missing its access key
+func (cmd *ConfigCommand) warnIncomplete() {
+	env, err := ResolveConfig(cmd.ConfigFile, cmd.Target)
+	if err == nil {
+		_, err = env.AzbConfig()
+	}
+
+	if err != nil {
+		cmd.logger.Info("Warning: environment %s is not usable yet: %s\n", cmd.Target, err)
+	}
+}
+
+type configTestResult struct {
+	Environment    string `json:"environment"`
+	StorageAccount string `json:"storageAccount"`
+	Endpoint       string `json:"endpoint"`
+	Ok             bool   `json:"ok"`
+	LatencyMs      int64  `json:"latencyMs"`
+	Error          string `json:"error,omitempty"`
+}
+
+func (cmd *ConfigCommand) test(ctx context.Context) error {
+	name := cmd.Target
+	if name == "" {
+		name = cmd.Environment
+	}
+
+	env, err := ResolveConfig(cmd.ConfigFile, name)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := env.AzbConfig()
+	if err != nil {
+		return err
+	}
+
+	res := &configTestResult{
+		Environment:    env.Environment,
+		StorageAccount: cfg.Name,
+		Endpoint:       cfg.BlobServiceURL(),
+	}
+
+	// Report the first failure rather than waiting out retries
+	cfg.Retry.MaxAttempts = 1
+
+	client, err := cfg.getBlobStorageClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Listing a single container is about the cheapest call that still
+	// proves the key is valid
+	start := time.Now()
+	_, err = client.ListContainers(storage.ListContainersParameters{MaxResults: 1})
+	res.LatencyMs = int64(time.Since(start) / time.Millisecond)
+
+	res.Ok = err == nil
+	if err != nil {
+		res.Error = describeTestError(err)
+	}
+
+	cmd.testReport(res)
+
+	if !res.Ok {
+		return ErrConfigTestFailed
+	}
+
+	return nil
+}
+
+func describeTestError(err error) string {
+	switch e := err.(type) {
+	case storage.AzureStorageServiceError:
+		switch e.StatusCode {
+		case 403:
+			if e.Code == "AuthenticationFailed" {
+				return "authentication failed: the access key was rejected (" + e.Message + ")"
+			}
+			return "permission denied: the key may not list containers (" + e.Code + ")"
+		case 404:
+			return "storage account not found"
+		}
+	case *url.Error:
+		return "could not reach the blob service: " + e.Err.Error()
+	}
+
+	return err.Error()
+}
+
+func (cmd *ConfigCommand) testReport(res *configTestResult) {
+	if cmd.outputMode == "json" {
+		s, _ := json.Marshal(res)
+		cmd.logger.Info("%s\n", s)
+	} else if res.Ok {
+		cmd.logger.Info("ok    %s (%s) responded in %dms\n", res.Environment, res.Endpoint, res.LatencyMs)
+	} else {
+		cmd.logger.Info("FAIL  %s (%s) after %dms: %s\n", res.Environment, res.Endpoint, res.LatencyMs, res.Error)
+	}
+}
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func writeConfig(c *C, path, contents string) {
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(path, []byte(contents), 0644), IsNil)
+}
+
+func (s *S) TestConfigLayers(c *C) {
+	root := c.MkDir()
+
+	project := filepath.Join(root, "project")
+	writeConfig(c, filepath.Join(project, localConfigName), `
+[default]
+storage_account_name = "projectaccount"
+`)
+
+	xdg := filepath.Join(root, "xdg")
+	writeConfig(c, filepath.Join(xdg, "azb", "config.toml"), `
+[default]
+storage_account_name = "useraccount"
+storage_account_access_key = "dXNlcmtleQ=="
+protocol = "http"
+`)
+
+	system := filepath.Join(root, "system.toml")
+	writeConfig(c, system, `
+[default]
+api_version = "2015-04-05"
+protocol = "https"
+
+[other]
+storage_account_name = "otheraccount"
+`)
+
+	// Run from a subdirectory so the project config has to be found by
+	// walking up
+	nested := filepath.Join(project, "a", "b")
+	c.Assert(os.MkdirAll(nested, 0755), IsNil)
+
+	wd, _ := os.Getwd()
+	c.Assert(os.Chdir(nested), IsNil)
+	defer os.Chdir(wd)
+
+	oldSystem := systemConfigFile
+	systemConfigFile = system
+	defer func() { systemConfigFile = oldSystem }()
+
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", xdg)
+	defer os.Unsetenv(envVarName("blob_endpoint"))
+	os.Setenv(envVarName("blob_endpoint"), "example.test")
+
+	env, err := ResolveConfig("", "")
+	c.Assert(err, IsNil)
+	c.Assert(env.Environment, Equals, DefaultEnvironment)
+	c.Assert(env.Sources["storage_account_name"], Matches, ".*project.*")
+	c.Assert(env.Sources["blob_endpoint"], Equals, "$AZB_BLOB_ENDPOINT")
+	c.Assert(env.Display("storage_account_access_key"), Equals, "********eQ==")
+
+	cfg, err := env.AzbConfig()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Name, Equals, "projectaccount")
+	c.Assert(cfg.AccessKey, Equals, "dXNlcmtleQ==")
+	c.Assert(cfg.BlobEndpoint, Equals, "example.test")
+	c.Assert(cfg.APIVersion, Equals, "2015-04-05")
+	c.Assert(cfg.UseHTTPS, Equals, false)
+
+	// AZB_* variables only stand in for the environment they're meant for,
+	// and leave others to their files
+	_, err = ResolveConfig(system, "missing")
+	c.Assert(err, Equals, ErrEnvironmentNotFound)
+	defer os.Unsetenv(envVarName("storage_account_name"))
+	os.Setenv(envVarName("storage_account_name"), "envaccount")
+	env, err = ResolveConfig(system, "other")
+	c.Assert(err, IsNil)
+	c.Assert(env.Values["storage_account_name"], Equals, "otheraccount")
+	c.Assert(env.Sources["storage_account_name"], Equals, system)
+	_, set := env.Values["blob_endpoint"]
+	c.Assert(set, Equals, false)
+	os.Unsetenv(envVarName("storage_account_name"))
+	defer os.Unsetenv(envVarPrefix + "ENVIRONMENT")
+	os.Setenv(envVarPrefix+"ENVIRONMENT", "missing")
+	env, err = ResolveConfig(system, "")
+	c.Assert(err, IsNil)
+	c.Assert(env.Environment, Equals, "missing")
+	os.Unsetenv(envVarPrefix + "ENVIRONMENT")
+
+	// An explicit file replaces the search path.  Without AZB_* variables,
+	// an environment has to be defined in it.
+	os.Unsetenv(envVarName("blob_endpoint"))
+	_, err = ResolveConfig(system, "default")
+	c.Assert(err, IsNil)
+	_, err = GetConfig(system, "other")
+	c.Assert(err, ErrorMatches, "Missing storage_account_name.*")
+	_, err = ResolveConfig(system, "missing")
+	c.Assert(err, Equals, ErrEnvironmentNotFound)
+}
+
+func (s *S) TestConfigEmulator(c *C) {
+	path := filepath.Join(c.MkDir(), "azb.toml")
+	writeConfig(c, path, `
+[local]
+use_emulator = true
+`)
+
+	cfg, err := GetConfig(path, "local")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Emulator, Equals, true)
+	c.Assert(cfg.UseHTTPS, Equals, false)
+	c.Assert(cfg.Name, Equals, "devstoreaccount1")
+}
+
+func (s *S) TestConfigRoundTrip(c *C) {
+	path := filepath.Join(c.MkDir(), "azb.toml")
+	writeConfig(c, path, `
+[keep]
+storage_account_name = "keepaccount"
+storage_account_access_key = "a2VlcA=="
+`)
+
+	config, err := readConfigFile(path)
+	c.Assert(err, IsNil)
+
+	emulator, err := ParseConfigValue("use_emulator", "true")
+	c.Assert(err, IsNil)
+	config["local"] = map[string]interface{}{"use_emulator": emulator}
+	c.Assert(writeConfigFile(path, config), IsNil)
+
+	_, err = ParseConfigValue("use_emulator", "maybe")
+	c.Assert(err, NotNil)
+	_, err = ParseConfigValue("no_such_key", "x")
+	c.Assert(err, Equals, ErrUnknownConfigKey)
+
+	cfg, err := GetConfig(path, "local")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Emulator, Equals, true)
+
+	cfg, err = GetConfig(path, "keep")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Name, Equals, "keepaccount")
+}
+
+func (s *S) TestConfigCommands(c *C) {
+	path := filepath.Join(c.MkDir(), "azb.toml")
+	writeConfig(c, path, `
+# this comment is lost on the first write
+[keep]
+storage_account_name = "keepaccount"
+storage_account_access_key = "a2VlcA=="
+`)
+
+	run := func(cmd *ConfigCommand) (string, error) {
+		lg := &bufferLogger{}
+		cmd.ConfigFile = path
+		cmd.SetLogger(lg)
+		err := cmd.Dispatch(context.Background())
+		return lg.String(), err
+	}
+
+	out, err := run(&ConfigCommand{Subcommand: "add", Target: "new",
+		Settings: []string{"storage_account_name=newaccount"}})
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "Added environment new to "+path+"\n"+
+		"Warning: environment new is not usable yet: Missing storage_account_name and/or storage_account_access_key for environment new\n")
+
+	_, err = run(&ConfigCommand{Subcommand: "add", Target: "keep"})
+	c.Assert(err, Equals, ErrEnvironmentExists)
+
+	out, err = run(&ConfigCommand{Subcommand: "set", Target: "new", Key: "storage_account_access_key", Value: "bmV3"})
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "Set storage_account_access_key in environment new of "+path+"\n")
+
+	cfg, err := GetConfig(path, "new")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Name, Equals, "newaccount")
+	c.Assert(cfg.AccessKey, Equals, "bmV3")
+
+	out, err = run(&ConfigCommand{Subcommand: "list"})
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, fmt.Sprintf("%-20s %s\n%-20s %s\n", "keep", path, "new", path))
+
+	// Without -f, remove only says what it would do
+	out, err = run(&ConfigCommand{Subcommand: "remove", Target: "keep"})
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "Would remove environment keep from "+path+"\n")
+
+	out, err = run(&ConfigCommand{Subcommand: "remove", Target: "keep", destructive: true})
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "Removed environment keep from "+path+"\n")
+
+	_, err = run(&ConfigCommand{Subcommand: "set", Target: "keep", Key: "protocol", Value: "http"})
+	c.Assert(err, Equals, ErrEnvironmentNotFound)
+
+	out, err = run(&ConfigCommand{Subcommand: "list"})
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, fmt.Sprintf("%-20s %s\n", "new", path))
+
+	b, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(b), "comment"), Equals, false)
+}
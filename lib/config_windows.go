@@ -0,0 +1,11 @@
+package lib
+
+import "os"
+
+func init() {
+	systemConfigFile = `C:\_azb.toml`
+
+	userConfigDir = func() string {
+		return os.Getenv("APPDATA")
+	}
+}
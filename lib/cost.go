@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+var (
+	ErrNoPrices = errors.New("no hot-tier storage price configured; set price_hot, and price_cool and price_archive for savings, or use --prices")
+)
+
+// Storage is billed per binary gigabyte
+const bytesPerGB = 1 << 30
+
+// The storage client can't see access tiers, so every blob is priced as if
+// in the account's default tier, and the estimate is labelled as such
+const assumedTier = "hot"
+
+// StoragePrices are the monthly prices of storing a GB in each access tier
+type StoragePrices struct {
+	Hot     float64 `toml:"hot" json:"hot"`
+	Cool    float64 `toml:"cool" json:"cool"`
+	Archive float64 `toml:"archive" json:"archive"`
+}
+
+// LoadPriceFile reads prices from a TOML file of the form
+//
+//	hot = 0.0184
+//	cool = 0.01
+//	archive = 0.002
+func LoadPriceFile(path string) (StoragePrices, error) {
+	var p StoragePrices
+	if _, err := toml.DecodeFile(path, &p); err != nil {
+		return p, fmt.Errorf("Invalid price file %s: %s", path, err)
+	}
+
+	return p, nil
+}
+
+func (p StoragePrices) forTier(tier string) float64 {
+	switch tier {
+	case "cool":
+		return p.Cool
+	case "archive":
+		return p.Archive
+	default:
+		return p.Hot
+	}
+}
+
+// CostCommand estimates the monthly cost of storing its sources at hot-tier
+// prices, and what moving older blobs from hot to a cheaper tier would
+// save.  It shares SizeCommand's workers.
+type CostCommand struct {
+	PriceFile     string // overrides the configured prices
+	OlderThanDays int    // age at which blobs are candidates for a cheaper tier
+	SizeCommand
+}
+
+type costEntry struct {
+	Name  string  `json:"name"`
+	Size  int64   `json:"size"`
+	Blobs int     `json:"blobs"`
+	Cost  float64 `json:"monthlyCost"`
+}
+
+func (e *costEntry) add(b *blob) {
+	e.Size += b.ContentLength
+	e.Blobs++
+}
+
+// costSaving is the saving from moving the older blobs to a tier
+type costSaving struct {
+	Tier   string  `json:"tier"`
+	Saving float64 `json:"monthlySaving"`
+}
+
+func (cmd *CostCommand) Dispatch(ctx context.Context) error {
+	prices := cmd.config.Prices
+	if cmd.PriceFile != "" {
+		var err error
+		if prices, err = LoadPriceFile(cmd.PriceFile); err != nil {
+			return err
+		}
+	}
+
+	// Everything is priced as hot, so that price at least is needed
+	if prices.forTier(assumedTier) == 0 {
+		return ErrNoPrices
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -cmd.OlderThanDays)
+
+	containers := map[string]*costEntry{}
+	total := &costEntry{Name: "total"}
+	old := &costEntry{Name: fmt.Sprintf("older than %d days", cmd.OlderThanDays)}
+
+	entry := func(m map[string]*costEntry, name string) *costEntry {
+		e, ok := m[name]
+		if !ok {
+			e = &costEntry{Name: name}
+			m[name] = e
+		}
+		return e
+	}
+
+	failures, err := cmd.scan(ctx, func(batch *sizeBatch) {
+		c := entry(containers, batch.container)
+		for _, b := range batch.blobs {
+			c.add(b)
+			total.add(b)
+			// Blobs of unknown age aren't candidates
+			if !b.LastModified.IsZero() && b.LastModified.Before(cutoff) {
+				old.add(b)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	price := func(e *costEntry) {
+		e.Cost = float64(e.Size) / bytesPerGB * prices.forTier(assumedTier)
+	}
+
+	byContainer := sortedCostEntries(containers)
+	for _, e := range byContainer {
+		price(e)
+	}
+	price(total)
+	price(old)
+
+	// A tier without a price would seem to be free, so it's left out
+	savings := []*costSaving{}
+	for _, tier := range []string{"cool", "archive"} {
+		if prices.forTier(tier) == 0 {
+			continue
+		}
+		oldGB := float64(old.Size) / bytesPerGB
+		savings = append(savings, &costSaving{tier, oldGB * (prices.forTier(assumedTier) - prices.forTier(tier))})
+	}
+
+	return cmd.finishScan(ctx, total.Blobs, total.Size, failures, func() error {
+		cmd.costReport(prices, byContainer, total, old, savings, failures)
+		return nil
+	})
+}
+
+func sortedCostEntries(m map[string]*costEntry) []*costEntry {
+	arr := []*costEntry{}
+	for _, e := range m {
+		arr = append(arr, e)
+	}
+
+	sort.Slice(arr, func(i, j int) bool { return arr[i].Name < arr[j].Name })
+
+	return arr
+}
+
+func (cmd *CostCommand) costReport(prices StoragePrices, containers []*costEntry,
+	total, old *costEntry, savings []*costSaving, failures []*sizeError) {
+
+	if cmd.outputMode == "json" {
+		tmp := struct {
+			StorageAccount string        `json:"storageAccount"`
+			Prices         StoragePrices `json:"prices"`
+			AssumedTier    string        `json:"assumedTier"`
+			Containers     []*costEntry  `json:"containers"`
+			Total          *costEntry    `json:"total"`
+			OlderThanDays  int           `json:"olderThanDays"`
+			Older          *costEntry    `json:"older"`
+			Savings        []*costSaving `json:"savings"`
+			Errors         []*sizeError  `json:"errors"`
+		}{
+			StorageAccount: cmd.config.Name,
+			Prices:         prices,
+			AssumedTier:    assumedTier,
+			Containers:     containers,
+			Total:          total,
+			OlderThanDays:  cmd.OlderThanDays,
+			Older:          old,
+			Savings:        savings,
+			Errors:         failures,
+		}
+
+		s, _ := json.Marshal(tmp)
+		cmd.logger.Info("%s\n", s)
+		return
+	}
+
+	row := func(e *costEntry) {
+		cmd.logger.Info("%9s %10d %12.2f  %s\n", formatSize(e.Size), e.Blobs, e.Cost, e.Name)
+	}
+
+	// Don't let the estimate pass for a bill by tier
+	cmd.logger.Info("Every blob is priced as %s; the storage client can't read access tiers\n", assumedTier)
+	cmd.logger.Info("By container (monthly cost):\n")
+	for _, e := range containers {
+		row(e)
+	}
+	row(total)
+
+	cmd.logger.Info("%s of blobs are older than %d days.  If they're %s now:\n", formatSize(old.Size), cmd.OlderThanDays, assumedTier)
+	for _, s := range savings {
+		cmd.logger.Info("  moving them to %s would save %.2f a month\n", s.Tier, s.Saving)
+	}
+
+	reportFailures(cmd.logger, failures)
+}
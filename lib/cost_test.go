@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestCost(c *C) {
+	gb := int64(bytesPerGB)
+	old := testBlob("2015/a.log", 3*gb)
+	old.Properties.LastModified = time.Now().AddDate(-1, 0, 0).UTC().Format(time.RFC1123)
+	recent := testBlob("b.log", gb)
+	recent.Properties.LastModified = time.Now().UTC().Format(time.RFC1123)
+
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {old, recent},
+		"www":  {testBlob("index.html", gb)},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	run := func(cmd *CostCommand) (string, error) {
+		lg := &bufferLogger{}
+		cmd.SetConfig(cfg)
+		cmd.SetLogger(lg)
+		if cmd.outputMode == "" {
+			cmd.SetOutputMode("json")
+		}
+		cmd.SetWorkers(2)
+		cmd.AddSource(&BlobSpec{})
+		err := cmd.Dispatch(context.Background())
+		return lg.String(), err
+	}
+
+	_, err := run(&CostCommand{OlderThanDays: 90})
+	c.Assert(err, Equals, ErrNoPrices)
+
+	prices := filepath.Join(c.MkDir(), "prices.toml")
+	c.Assert(ioutil.WriteFile(prices, []byte("hot = 0.02\ncool = 0.01\narchive = 0.002\n"), 0600), IsNil)
+
+	out, err := run(&CostCommand{OlderThanDays: 90, PriceFile: prices})
+	c.Assert(err, IsNil)
+
+	var report struct {
+		Containers  []*costEntry
+		AssumedTier string
+		Tiers       []*costEntry
+		Total       *costEntry
+		Older       *costEntry
+		Savings     []*costSaving
+	}
+	c.Assert(json.Unmarshal([]byte(out), &report), IsNil)
+	c.Assert(report.Containers, DeepEquals, []*costEntry{{"logs", 4 * gb, 2, 0.08}, {"www", gb, 1, 0.02}})
+	c.Assert(report.Total, DeepEquals, &costEntry{"total", 5 * gb, 3, 0.1})
+
+	// Tiers can't be read, so there's no breakdown by them
+	c.Assert(report.AssumedTier, Equals, "hot")
+	c.Assert(report.Tiers, IsNil)
+	c.Assert(report.Older.Size, Equals, 3*gb)
+	c.Assert(report.Savings[0].Tier, Equals, "cool")
+	c.Assert(report.Savings[0].Saving, Equals, 0.03)
+	c.Assert(report.Savings[1].Saving > 0.053 && report.Savings[1].Saving < 0.055, Equals, true)
+
+	text := &CostCommand{OlderThanDays: 90, PriceFile: prices}
+	text.SetOutputMode("text")
+	out, err = run(text)
+	c.Assert(err, IsNil)
+	c.Assert(out, Matches, "Every blob is priced as hot; the storage client can't read access tiers\n(.|\n)*")
+	c.Assert(out, Matches, "(.|\n)*older than 90 days.  If they're hot now:\n(.|\n)*")
+
+	// Tiers without a price are left out of the savings, rather than seeming
+	// to be free
+	c.Assert(ioutil.WriteFile(prices, []byte("hot = 0.02\ncool = 0.01\n"), 0600), IsNil)
+	out, err = run(&CostCommand{OlderThanDays: 90, PriceFile: prices})
+	c.Assert(err, IsNil)
+	report.Savings = nil
+	c.Assert(json.Unmarshal([]byte(out), &report), IsNil)
+	c.Assert(report.Savings, DeepEquals, []*costSaving{{"cool", 0.03}})
+
+	// Without a hot price, nothing can be estimated
+	c.Assert(ioutil.WriteFile(prices, []byte("cool = 0.01\narchive = 0.002\n"), 0600), IsNil)
+	_, err = run(&CostCommand{OlderThanDays: 90, PriceFile: prices})
+	c.Assert(err, Equals, ErrNoPrices)
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// DuCommand breaks the size of its sources down by container and virtual
+// directory, in the manner of du.  It shares SizeCommand's workers, so a
+// scan is just as parallel.
+type DuCommand struct {
+	Depth      int  // directory levels below each container to report
+	SortBySize bool // largest first, rather than by path
+	SizeCommand
+}
+
+type duEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Blobs int    `json:"blobs"`
+}
+
+func (cmd *DuCommand) Dispatch(ctx context.Context) error {
+	entries := map[string]*duEntry{}
+	total := &duEntry{Path: "total"}
+
+	add := func(path string, size int64) {
+		e, ok := entries[path]
+		if !ok {
+			e = &duEntry{Path: path}
+			entries[path] = e
+		}
+		e.Size += size
+		e.Blobs++
+	}
+
+	failures, err := cmd.scan(ctx, func(batch *sizeBatch) {
+		for _, b := range batch.blobs {
+			for _, dir := range duPaths(batch.container, b.Name, cmd.Depth) {
+				add(dir, b.ContentLength)
+			}
+			total.Size += b.ContentLength
+			total.Blobs++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	arr := []*duEntry{}
+	for _, e := range entries {
+		arr = append(arr, e)
+	}
+
+	if cmd.SortBySize {
+		sort.Slice(arr, func(i, j int) bool {
+			if arr[i].Size != arr[j].Size {
+				return arr[i].Size > arr[j].Size
+			}
+			return arr[i].Path < arr[j].Path
+		})
+	} else {
+		sort.Slice(arr, func(i, j int) bool { return arr[i].Path < arr[j].Path })
+	}
+
+	return cmd.finishScan(ctx, total.Blobs, total.Size, failures, func() error {
+		cmd.duReport(arr, total, failures)
+		return nil
+	})
+}
+
+// duPaths lists the container and each virtual directory, down to depth,
+// that a blob's size counts towards.  e.g. logs/2016/05/app.log at depth 2
+// counts towards logs/, logs/2016/ and logs/2016/05/
+func duPaths(container, name string, depth int) []string {
+	path := container + "/"
+	paths := []string{path}
+
+	dirs := strings.Split(name, "/")
+	dirs = dirs[:len(dirs)-1]
+	for i := 0; i < depth && i < len(dirs); i++ {
+		path += dirs[i] + "/"
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+func (cmd *DuCommand) duReport(arr []*duEntry, total *duEntry, failures []*sizeError) {
+	if cmd.outputMode == "json" {
+		tmp := struct {
+			StorageAccount string       `json:"storageAccount"`
+			Depth          int          `json:"depth"`
+			Entries        []*duEntry   `json:"entries"`
+			Total          *duEntry     `json:"total"`
+			Errors         []*sizeError `json:"errors"`
+		}{
+			StorageAccount: cmd.config.Name,
+			Depth:          cmd.Depth,
+			Entries:        arr,
+			Total:          total,
+			Errors:         failures,
+		}
+
+		s, _ := json.Marshal(tmp)
+		cmd.logger.Info("%s\n", s)
+		return
+	}
+
+	for _, e := range append(arr, total) {
+		cmd.logger.Info("%9s %10d  %s\n", formatSize(e.Size), e.Blobs, e.Path)
+	}
+
+	reportFailures(cmd.logger, failures)
+}
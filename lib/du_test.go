@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestDuPaths(c *C) {
+	c.Assert(duPaths("logs", "2016/05/app.log", 0), DeepEquals, []string{"logs/"})
+	c.Assert(duPaths("logs", "2016/05/app.log", 1), DeepEquals, []string{"logs/", "logs/2016/"})
+	c.Assert(duPaths("logs", "2016/05/app.log", 5), DeepEquals, []string{"logs/", "logs/2016/", "logs/2016/05/"})
+	c.Assert(duPaths("logs", "app.log", 2), DeepEquals, []string{"logs/"})
+}
+
+func (s *S) TestDu(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {testBlob("2016/a.log", 1500), testBlob("2017/b.log", 2500), testBlob("c.log", 1)},
+		"www":  {testBlob("index.html", 100)},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	lg := &bufferLogger{}
+	cmd := &DuCommand{Depth: 1, SortBySize: true}
+	cmd.SetConfig(cfg)
+	cmd.SetLogger(lg)
+	cmd.SetWorkers(2)
+	cmd.AddSource(&BlobSpec{})
+
+	c.Assert(cmd.Dispatch(context.Background()), IsNil)
+	c.Assert(lg.String(), Equals, ""+
+		"  4.00 KB          3  logs/\n"+
+		"  2.50 KB          1  logs/2017/\n"+
+		"  1.50 KB          1  logs/2016/\n"+
+		"    100 B          1  www/\n"+
+		"  4.10 KB          4  total\n")
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBadAge  = errors.New("malformed age; expected a time (e.g. 2016-05-01T00:00:00Z), a duration (e.g. 36h) or days (e.g. 7d)")
+	ErrBadSize = errors.New("malformed size; expected bytes, or a number ending in k, M, G or T")
+
+	ErrFilterNeedsRecursive = errors.New("--newer-than, --older-than, --min-size and --max-size select among the blobs -r lists; use -r or a pattern")
+)
+
+// sizeUnits are the binary multiples a size may end in, as in find(1)
+var sizeUnits = map[byte]int64{
+	'c': 1,
+	'k': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+	'T': 1 << 40,
+}
+
+// BlobFilter selects blobs by age and size.  Zero fields don't filter.
+// MaxSize is set apart by HasMaxSize, so a MaxSize of 0 selects empty blobs.
+type BlobFilter struct {
+	NewerThan  time.Time
+	OlderThan  time.Time
+	MinSize    int64
+	MaxSize    int64
+	HasMaxSize bool
+}
+
+// IsZero reports whether the filter lets every blob through
+func (f BlobFilter) IsZero() bool {
+	return f.NewerThan.IsZero() && f.OlderThan.IsZero() && f.MinSize == 0 && !f.HasMaxSize
+}
+
+// Matches reports whether the blob passes every filter.  Blobs of unknown
+// age never pass a filter on age.
+func (f BlobFilter) Matches(b *blob) bool {
+	if !f.NewerThan.IsZero() && (b.LastModified.IsZero() || !b.LastModified.After(f.NewerThan)) {
+		return false
+	}
+	if !f.OlderThan.IsZero() && (b.LastModified.IsZero() || !b.LastModified.Before(f.OlderThan)) {
+		return false
+	}
+	if f.MinSize > 0 && b.ContentLength < f.MinSize {
+		return false
+	}
+	if f.HasMaxSize && b.ContentLength > f.MaxSize {
+		return false
+	}
+
+	return true
+}
+
+// ParseAge reads a point in time: an RFC3339 time or date, or an age before
+// now as a duration (36h) or a number of days (7d, or just 7)
+func ParseAge(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+		if days < 0 {
+			return time.Time{}, ErrBadAge
+		}
+		return now.AddDate(0, 0, -days), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, ErrBadAge
+	}
+
+	return now.Add(-d), nil
+}
+
+// ParseSize reads a number of bytes, optionally in k, M, G or T
+func ParseSize(s string) (int64, error) {
+	n, unit, err := splitSizeUnit(s)
+	if err != nil {
+		return 0, err
+	}
+	return n * unit, nil
+}
+
+func splitSizeUnit(s string) (n, unit int64, err error) {
+	unit = 1
+	if s != "" {
+		if u, ok := sizeUnits[s[len(s)-1]]; ok {
+			unit = u
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err = strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, 0, ErrBadSize
+	}
+
+	return n, unit, nil
+}
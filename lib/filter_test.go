@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestParseAge(c *C) {
+	now := time.Date(2016, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	for in, want := range map[string]time.Time{
+		"2016-05-01T08:00:00Z": time.Date(2016, 5, 1, 8, 0, 0, 0, time.UTC),
+		"2016-05-01":           time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC),
+		"36h":                  now.Add(-36 * time.Hour),
+		"7d":                   now.AddDate(0, 0, -7),
+		"7":                    now.AddDate(0, 0, -7),
+	} {
+		t, err := ParseAge(in, now)
+		c.Check(err, IsNil)
+		c.Check(t.Equal(want), Equals, true, Commentf("%s: %s", in, t))
+	}
+
+	for _, in := range []string{"", "yesterday", "-3d", "-1h"} {
+		_, err := ParseAge(in, now)
+		c.Check(err, Equals, ErrBadAge, Commentf("%s", in))
+	}
+
+	n, err := ParseSize("100M")
+	c.Check(err, IsNil)
+	c.Check(n, Equals, int64(100<<20))
+	n, err = ParseSize("1500")
+	c.Check(err, IsNil)
+	c.Check(n, Equals, int64(1500))
+	_, err = ParseSize("1.5G")
+	c.Check(err, Equals, ErrBadSize)
+}
+
+func (s *S) TestBlobFilter(c *C) {
+	blob := func(name, modified string, size int64) storage.Blob {
+		b := testBlob(name, size)
+		b.Properties.LastModified = modified
+		return b
+	}
+
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {
+			blob("a.log", "Sun, 01 May 2016 10:00:00 GMT", 100),
+			blob("b.log", "Mon, 09 May 2016 10:00:00 GMT", 5000),
+			blob("c.log", "", 5000),
+			blob("d.log", "Tue, 10 May 2016 10:00:00 GMT", 10),
+		},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	may5 := time.Date(2016, 5, 5, 0, 0, 0, 0, time.UTC)
+	run := func(f BlobFilter) string {
+		lg := &bufferLogger{}
+		cmd := &SimpleCommand{Command: "ls"}
+		cmd.SetConfig(cfg)
+		cmd.SetLogger(lg)
+		cmd.AddSource(&BlobSpec{Container: "logs", PathPresent: true, Filter: f})
+		c.Assert(cmd.Dispatch(context.Background()), IsNil)
+		return lg.String()
+	}
+
+	c.Assert(run(BlobFilter{}), Equals, "a.log\nb.log\nc.log\nd.log\n")
+	c.Assert(run(BlobFilter{NewerThan: may5}), Equals, "b.log\nd.log\n")
+	c.Assert(run(BlobFilter{OlderThan: may5}), Equals, "a.log\n")
+	c.Assert(run(BlobFilter{MinSize: 100}), Equals, "a.log\nb.log\nc.log\n")
+	c.Assert(run(BlobFilter{NewerThan: may5, MaxSize: 1000, HasMaxSize: true}), Equals, "d.log\n")
+
+	// A MaxSize of 0 selects only empty blobs
+	empty := BlobFilter{HasMaxSize: true}
+	c.Assert(empty.IsZero(), Equals, false)
+	c.Assert(empty.Matches(newBlob(testBlob("empty", 0))), Equals, true)
+	c.Assert(empty.Matches(newBlob(testBlob("full", 1))), Equals, false)
+	c.Assert(BlobFilter{}.Matches(newBlob(testBlob("full", 1))), Equals, true)
+
+	// rm and get only filter the blobs -r lists, so a filter on one blob is
+	// refused rather than ignored
+	for _, command := range []string{"rm", "get"} {
+		cmd := &SimpleCommand{Command: command}
+		cmd.SetConfig(cfg)
+		cmd.SetLogger(&bufferLogger{})
+		cmd.SetDestructive(true)
+		cmd.AddSource(&BlobSpec{Container: "logs", Path: "d.log", PathPresent: true, Filter: BlobFilter{OlderThan: may5}})
+		c.Assert(cmd.Dispatch(context.Background()), Equals, ErrFilterNeedsRecursive)
+	}
+	c.Assert(svc.blobNames("logs"), HasLen, 4)
+
+	// size totals only what the filter selects
+	lg := &bufferLogger{}
+	size := &SizeCommand{}
+	size.SetConfig(cfg)
+	size.SetLogger(lg)
+	size.SetOutputMode("json")
+	size.SetWorkers(2)
+	size.AddSource(&BlobSpec{Container: "logs", Filter: BlobFilter{MinSize: 1000}})
+	c.Assert(size.Dispatch(context.Background()), IsNil)
+	c.Assert(lg.String(), Matches, `.*"size":10000,"blobs":2,.*\n`)
+}
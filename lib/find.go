@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+// findRun is the state actions need while find walks the listing
+type findRun struct {
+	cmd    *SimpleCommand
+	client *storage.BlobStorageClient
+	found  int
+}
+
+func (cmd *SimpleCommand) find() error {
+	if cmd.source == nil || cmd.destination != nil || cmd.source.Container == "" {
+		return ErrUnrecognizedCommand
+	}
+
+	expr, err := parseFindExpression(cmd.Expression, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// get the client
+	client, err := cmd.config.getBlobStorageClient(cmd.ctx)
+	if err != nil {
+		return err
+	}
+
+	// Act on each page as it arrives, rather than listing everything first
+	r := &findRun{cmd: cmd, client: client}
+	err = cmd.eachBlobPage(client, "", func(blobs []*blob, _ []string) error {
+		for _, b := range blobs {
+			if cmd.ctx.Err() != nil {
+				return ErrInterrupted
+			}
+			ok, err := expr.eval(r, b)
+			if err != nil {
+				return err
+			}
+			if ok {
+				r.found++
+			}
+		}
+		return nil
+	})
+
+	if err == ErrInterrupted {
+		cmd.logger.Info("Interrupted after matching %d blobs\n", r.found)
+	}
+	cmd.logger.Debug("Matched %d blobs\n", r.found)
+
+	return err
+}
+
+// blobPath names a blob the way azb's other commands take it
+func (r *findRun) blobPath(b *blob) string {
+	return r.cmd.source.Container + "/" + b.Name
+}
+
+func printBlob(r *findRun, b *blob) (bool, error) {
+	if r.cmd.outputMode == "json" {
+		tmp := struct {
+			Container string `json:"container"`
+			*blob
+		}{r.cmd.source.Container, b}
+
+		s, _ := json.Marshal(tmp)
+		r.cmd.logger.Info("%s\n", s)
+	} else {
+		r.cmd.logger.Info("%s\n", r.blobPath(b))
+	}
+	return true, nil
+}
+
+func printBlob0(r *findRun, b *blob) (bool, error) {
+	r.cmd.logger.Info("%s\x00", r.blobPath(b))
+	return true, nil
+}
+
+// deleteBlob removes the blob, or without -f says it would
+func deleteBlob(r *findRun, b *blob) (bool, error) {
+	if !r.cmd.destructive {
+		r.cmd.logger.Info("Would remove %s\n", r.blobPath(b))
+		return true, nil
+	}
+
+	if err := removeBlob(r.client, r.cmd.source.Container, b.Name); err != nil {
+		return false, interrupted(r.cmd.ctx, err)
+	}
+	r.cmd.logger.Debug("Removed %s\n", r.blobPath(b))
+
+	return true, nil
+}
+
+// exec runs a command with {} replaced by the blob's path.  It matches if
+// the command succeeds.
+func (r *findRun) exec(argv []string, b *blob) (bool, error) {
+	args := make([]string, len(argv))
+	for i, a := range argv {
+		args[i] = strings.Replace(a, "{}", r.blobPath(b), -1)
+	}
+
+	c := exec.CommandContext(r.cmd.ctx, args[0], args[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		if r.cmd.ctx.Err() != nil {
+			return false, ErrInterrupted
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,299 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// findExpr is a node of a find expression.  Tests look at the blob; actions
+// act on it.  Either way the result says whether the blob still matches.
+type findExpr interface {
+	eval(r *findRun, b *blob) (bool, error)
+}
+
+type findAnd struct{ left, right findExpr }
+type findOr struct{ left, right findExpr }
+type findNot struct{ expr findExpr }
+
+// findTest is a predicate on the blob alone
+type findTest func(b *blob) bool
+
+// findAction does something with a matching blob
+type findAction func(r *findRun, b *blob) (bool, error)
+
+func (e findAnd) eval(r *findRun, b *blob) (bool, error) {
+	ok, err := e.left.eval(r, b)
+	if !ok || err != nil {
+		return false, err
+	}
+	return e.right.eval(r, b)
+}
+
+func (e findOr) eval(r *findRun, b *blob) (bool, error) {
+	ok, err := e.left.eval(r, b)
+	if ok || err != nil {
+		return ok, err
+	}
+	return e.right.eval(r, b)
+}
+
+func (e findNot) eval(r *findRun, b *blob) (bool, error) {
+	ok, err := e.expr.eval(r, b)
+	return !ok, err
+}
+
+func (t findTest) eval(r *findRun, b *blob) (bool, error)   { return t(b), nil }
+func (a findAction) eval(r *findRun, b *blob) (bool, error) { return a(r, b) }
+
+// findParser reads an expression in find(1)'s grammar:
+//
+//	expr := and { ( -or | -o ) and }
+//	and  := not { [ -and | -a ] not }
+//	not  := ( -not | ! ) not | ( expr ) | primary
+type findParser struct {
+	args      []string
+	pos       int
+	now       time.Time
+	hasAction bool
+}
+
+// parseFindExpression parses args into an expression.  As with find, an
+// expression without an action prints whatever it matches.
+func parseFindExpression(args []string, now time.Time) (findExpr, error) {
+	p := &findParser{args: args, now: now}
+	if len(args) == 0 {
+		return findAction(printBlob), nil
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.args) {
+		return nil, fmt.Errorf("find: unexpected %s", p.args[p.pos])
+	}
+
+	if !p.hasAction {
+		expr = findAnd{expr, findAction(printBlob)}
+	}
+
+	return expr, nil
+}
+
+func (p *findParser) peek() string {
+	if p.pos < len(p.args) {
+		return p.args[p.pos]
+	}
+	return ""
+}
+
+func (p *findParser) next() string {
+	s := p.peek()
+	p.pos++
+	return s
+}
+
+func (p *findParser) parseOr() (findExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "-or" || p.peek() == "-o" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = findOr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *findParser) parseAnd() (findExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.pos < len(p.args) {
+		switch p.peek() {
+		case "-or", "-o", ")":
+			return left, nil
+		case "-and", "-a":
+			p.next()
+		}
+
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = findAnd{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *findParser) parseNot() (findExpr, error) {
+	switch p.peek() {
+	case "-not", "!":
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return findNot{expr}, nil
+	case "(":
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("find: missing )")
+		}
+		return expr, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *findParser) parsePrimary() (findExpr, error) {
+	if p.pos >= len(p.args) {
+		return nil, fmt.Errorf("find: expected an expression")
+	}
+
+	primary := p.next()
+	arg := func() (string, error) {
+		if p.pos >= len(p.args) {
+			return "", fmt.Errorf("find: %s needs an argument", primary)
+		}
+		return p.next(), nil
+	}
+
+	switch primary {
+	case "-path":
+		// The same globs as blobspecs, so ** crosses directories
+		pattern, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		re, err := globToRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("find: bad pattern for %s: %s", primary, pattern)
+		}
+
+		return findTest(func(b *blob) bool { return re.MatchString(b.Name) }), nil
+	case "-name", "-type":
+		pattern, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("find: bad pattern for %s: %s", primary, pattern)
+		}
+
+		return findTest(func(b *blob) bool {
+			s := path.Base(b.Name)
+			if primary == "-type" {
+				s = b.ContentType
+			}
+			ok, _ := path.Match(pattern, s)
+			return ok
+		}), nil
+	case "-size":
+		s, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		return parseSizeTest(s)
+	case "-mtime":
+		s, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		return parseMtimeTest(s, p.now)
+	case "-print":
+		p.hasAction = true
+		return findAction(printBlob), nil
+	case "-print0":
+		p.hasAction = true
+		return findAction(printBlob0), nil
+	case "-delete":
+		p.hasAction = true
+		return findAction(deleteBlob), nil
+	case "-exec":
+		p.hasAction = true
+		argv := []string{}
+		for p.pos < len(p.args) && p.peek() != ";" {
+			argv = append(argv, p.next())
+		}
+		if p.next() != ";" || len(argv) == 0 {
+			return nil, fmt.Errorf("find: -exec needs a command ending in ;")
+		}
+		return findAction(func(r *findRun, b *blob) (bool, error) {
+			return r.exec(argv, b)
+		}), nil
+	}
+
+	return nil, fmt.Errorf("find: unknown predicate %s", primary)
+}
+
+// findComparison splits find's numeric arguments: +n means more than n, -n
+// less than n, and n exactly n
+func findComparison(s string) (sign byte, rest string) {
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		return s[0], s[1:]
+	}
+	return 0, s
+}
+
+func compare(sign byte, have, want int64) bool {
+	switch sign {
+	case '+':
+		return have > want
+	case '-':
+		return have < want
+	default:
+		return have == want
+	}
+}
+
+// parseSizeTest reads -size [+-]n[ckMGT].  Unlike find, a bare number
+// counts bytes.  As in find, sizes round up to the unit, so -size -1M only
+// matches empty blobs.
+func parseSizeTest(s string) (findExpr, error) {
+	sign, rest := findComparison(s)
+	n, unit, err := splitSizeUnit(rest)
+	if err != nil {
+		return nil, fmt.Errorf("find: bad size %s", s)
+	}
+
+	return findTest(func(b *blob) bool {
+		units := int64(math.Ceil(float64(b.ContentLength) / float64(unit)))
+		return compare(sign, units, n)
+	}), nil
+}
+
+// parseMtimeTest reads -mtime [+-]n, the age in whole days.  Blobs of
+// unknown age never match.
+func parseMtimeTest(s string, now time.Time) (findExpr, error) {
+	sign, rest := findComparison(s)
+	n, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("find: bad age %s", s)
+	}
+
+	return findTest(func(b *blob) bool {
+		if b.LastModified.IsZero() {
+			return false
+		}
+		days := int64(now.Sub(b.LastModified) / (24 * time.Hour))
+		return compare(sign, days, n)
+	}), nil
+}
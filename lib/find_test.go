@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestFindExpression(c *C) {
+	now := time.Date(2016, 5, 10, 12, 0, 0, 0, time.UTC)
+	old := &blob{Name: "logs/app.log.gz", ContentLength: 200 << 20, ContentType: "application/gzip", LastModified: now.AddDate(0, 0, -30)}
+	recent := &blob{Name: "logs/app.log", ContentLength: 1500, ContentType: "text/plain", LastModified: now.Add(-time.Hour)}
+	empty := &blob{Name: "README", ContentType: "text/plain"}
+
+	matches := func(expr string, b *blob) bool {
+		e, err := parseFindExpression(strings.Fields(expr), now)
+		c.Assert(err, IsNil)
+		lg := &bufferLogger{}
+		ok, err := e.eval(&findRun{cmd: &SimpleCommand{source: &BlobSpec{Container: "www"}, logger: lg}}, b)
+		c.Assert(err, IsNil)
+		return ok
+	}
+
+	c.Check(matches("-name *.gz", old), Equals, true)
+	c.Check(matches("-name *.gz", recent), Equals, false)
+	c.Check(matches("-path logs/*", recent), Equals, true)
+	c.Check(matches("-path */app.log", recent), Equals, true)
+	c.Check(matches("-path *.log", recent), Equals, false)
+	c.Check(matches("-path **.log", recent), Equals, true)
+	c.Check(matches("-path **/*.gz", old), Equals, true)
+	c.Check(matches("-size +100M", old), Equals, true)
+	c.Check(matches("-size +100M", recent), Equals, false)
+	c.Check(matches("-size 2k", recent), Equals, true)
+	c.Check(matches("-size -1k", empty), Equals, true)
+	c.Check(matches("-mtime +7", old), Equals, true)
+	c.Check(matches("-mtime -7", recent), Equals, true)
+	c.Check(matches("-mtime -7", empty), Equals, false)
+	c.Check(matches("-type text/*", recent), Equals, true)
+	c.Check(matches("-name *.gz -or -mtime -1", recent), Equals, true)
+	c.Check(matches("-type text/* -and -not -name README", empty), Equals, false)
+	c.Check(matches("! ( -name *.gz -o -name README )", recent), Equals, true)
+
+	for _, bad := range []string{"-size", "-size 10X", "-mtime soon", "-tier cool", "-path [a", "-bogus", "( -name x", "-exec echo {}", "-name x )"} {
+		_, err := parseFindExpression(strings.Fields(bad), now)
+		c.Check(err, NotNil, Commentf("%s", bad))
+	}
+}
+
+func (s *S) TestFindActions(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {testBlob("2016/a.log", 10), testBlob("2016/b.gz", 5000), testBlob("README", 1)},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "find"}, "logs", "bare"), Equals, "logs/2016/a.log\nlogs/2016/b.gz\nlogs/README\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "find", Expression: []string{"-size", "+1k"}}, "logs/2016/", "bare"), Equals, "logs/2016/b.gz\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "find", Expression: []string{"-name", "*.log", "-print0"}}, "logs", "bare"), Equals, "logs/2016/a.log\x00")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "find", Expression: []string{"-name", "*.gz", "-delete"}}, "logs", "bare"), Equals, "Would remove logs/2016/b.gz\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "find", Expression: []string{"-name", "README"}}, "logs", "json"), Matches, `\{"container":"logs","name":"README",.*\}\n`)
+
+	// A delete that fails outright stops find, rather than panicking
+	ctx, cancel := context.WithCancel(context.Background())
+	svc.failDelete = func(name string) bool {
+		cancel()
+		return true
+	}
+	remove := &SimpleCommand{Command: "find", Expression: []string{"-name", "*.gz", "-delete"}}
+	remove.SetDestructive(true)
+	_, err := runCommand(c, ctx, cfg, remove, "bare", "logs")
+	c.Assert(err, Equals, ErrInterrupted)
+	c.Assert(svc.blobNames("logs"), HasLen, 3)
+
+	svc.failDelete = nil
+	remove = &SimpleCommand{Command: "find", Expression: []string{"-name", "*.gz", "-delete"}}
+	remove.SetDestructive(true)
+	c.Assert(runSimple(c, cfg, remove, "logs", "bare"), Equals, "")
+	c.Assert(svc.blobNames("logs"), DeepEquals, []string{"2016/a.log", "README"})
+}
@@ -1,87 +1,225 @@
 package lib
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/storage"
 )
 
+var (
+	ErrBadRange = errors.New("malformed range; expected start-end or start- (e.g. 0-1023)")
+)
+
 func (cmd *SimpleCommand) pullBlob() error {
 
 	// get the client
-	client, err := cmd.config.getBlobStorageClient()
+	client, err := cmd.config.getBlobStorageClient(cmd.ctx)
 	if err != nil {
 		return err
 	}
 
-	// query the endpoint
-	body, err := client.GetBlob(cmd.source.Container, cmd.source.Path)
-	if err != nil {
-		if sse, ok := err.(storage.AzureStorageServiceError); ok {
-			switch sse.Code {
-			case "ContainerNotFound":
-				return ErrContainerOrBlobNotFound
-			case "BlobNotFound":
-				return ErrContainerOrBlobNotFound
-			}
-		}
-		return err
+	if cmd.Recursive {
+		return cmd.pullBlobs(client)
 	}
 
 	if cmd.localPath == "" {
 		// echo content to stdout
-		_, err := io.Copy(os.Stdout, body)
-		if err != nil {
-			return err
+		_, err := cmd.download(client, cmd.source.Path, os.Stdout)
+		return err
+	}
+
+	written, err := cmd.downloadFile(client, cmd.source.Path, cmd.localPath)
+	if err != nil {
+		return err
+	}
+
+	// tell the world about it
+	return cmd.pullBlobReport(cmd.source.Path, cmd.localPath, written)
+}
+
+// pullBlobs downloads every blob under the source path, or matching its
+// pattern, into the local directory, keeping their names
+func (cmd *SimpleCommand) pullBlobs(client *storage.BlobStorageClient) error {
+	arr, err := cmd.listBlobsInternal(client)
+	if err != nil {
+		return err
+	}
+
+	dir := cmd.localPath
+	if dir == "" {
+		dir = "."
+	}
+
+	for _, u := range arr {
+		dst, ok := localBlobPath(dir, u.Name)
+		if !ok {
+			cmd.logger.Info("Skipping %s/%s: its name leads outside %s\n", cmd.source.Container, u.Name, dir)
+			continue
 		}
-	} else {
-		// prepare the download location
-		dir := filepath.Dir(cmd.localPath)
-		err = os.MkdirAll(dir, 0755)
+
+		written, err := cmd.downloadFile(client, u.Name, dst)
 		if err != nil {
 			return err
 		}
 
-		// put the file on disk
-		f, err := os.Create(cmd.localPath)
-		if err != nil {
+		if err := cmd.pullBlobReport(u.Name, dst, written); err != nil {
 			return err
 		}
+	}
 
-		defer f.Close()
+	cmd.logger.Debug("Downloaded %d blobs\n", len(arr))
 
-		written, err := io.Copy(f, body)
-		if err != nil {
-			return err
+	return nil
+}
+
+// localBlobPath places a blob under dir by its name.  Names can hold ..
+// and the like, so it refuses any that would lead outside dir.
+func localBlobPath(dir, name string) (string, bool) {
+	root := filepath.Clean(dir)
+	dst := filepath.Join(root, filepath.FromSlash(name))
+
+	rel, err := filepath.Rel(root, dst)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return dst, true
+}
+
+// download copies a blob of the source container to w: all of it, or the
+// bytes in cmd.Range
+func (cmd *SimpleCommand) download(client *storage.BlobStorageClient, name string, w io.Writer) (int64, error) {
+	return downloadRange(cmd.ctx, client, cmd.source.Container, name, cmd.Range, w)
+}
+
+// downloadRange copies the bytes of a blob in rng, an HTTP byte range such
+// as "0-1023" or "1024-", to w.  An empty range copies the whole blob.
+func downloadRange(ctx context.Context, client *storage.BlobStorageClient, container, name, rng string, w io.Writer) (int64, error) {
+	// query the endpoint
+	var body io.ReadCloser
+	var err error
+	if rng == "" {
+		body, err = client.GetBlob(container, name)
+	} else {
+		body, err = client.GetBlobRange(container, name, rng, nil)
+	}
+	if err != nil {
+		if sse, ok := err.(storage.AzureStorageServiceError); ok {
+			switch sse.Code {
+			case "ContainerNotFound":
+				return 0, ErrContainerOrBlobNotFound
+			case "BlobNotFound":
+				return 0, ErrContainerOrBlobNotFound
+			}
 		}
+		return 0, interrupted(ctx, err)
+	}
+
+	defer body.Close()
+
+	written, err := io.Copy(w, &contextReader{ctx, body})
+	return written, interrupted(ctx, err)
+}
+
+// checkByteRange accepts a range of bytes as start-end, counting from 0
+// and including end, or as start- for the rest of the blob
+func checkByteRange(rng string) error {
+	z := strings.SplitN(rng, "-", 2)
+	if len(z) != 2 {
+		return ErrBadRange
+	}
 
-		// tell the world about it
-		cmd.pullBlobReport(written)
+	start, err := strconv.ParseInt(z[0], 10, 64)
+	if err != nil || start < 0 {
+		return ErrBadRange
+	}
+	if z[1] == "" {
+		return nil
+	}
+
+	end, err := strconv.ParseInt(z[1], 10, 64)
+	if err != nil || end < start {
+		return ErrBadRange
 	}
 
 	return nil
 }
 
-func (cmd *SimpleCommand) pullBlobReport(written int64) {
-	if cmd.outputMode == "json" {
-		tmp := struct {
-			StorageAccount string `json:"storageAccount"`
-			Container      string `json:"container"`
-			Blob           string `json:"blob"`
-			BytesWritten   int64  `json:"bytesWritten"`
-			Destination    string `json:"destination"`
-		}{
-			StorageAccount: cmd.config.Name,
-			Container:      cmd.source.Container,
-			Blob:           cmd.source.Path,
-			BytesWritten:   written,
-			Destination:    cmd.localPath,
+// downloadFile saves a blob of the source container to localPath
+func (cmd *SimpleCommand) downloadFile(client *storage.BlobStorageClient, name, localPath string) (int64, error) {
+	// prepare the download location
+	dir := filepath.Dir(localPath)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	// put the file on disk
+	f, err := os.Create(localPath)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := cmd.download(client, name, f)
+	f.Close()
+	if err != nil {
+		// don't leave a truncated file behind
+		os.Remove(localPath)
+		if err == ErrInterrupted {
+			cmd.logger.Info("Interrupted after %d bytes of %s/%s; removed partial download %s\n",
+				written, cmd.source.Container, name, localPath)
 		}
+		return written, err
+	}
+
+	return written, nil
+}
+
+// contextReader stops a copy as soon as ctx is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
 
-		s, _ := json.Marshal(tmp)
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cr.r.Read(p)
+}
+
+// pullRecord describes a downloaded blob
+type pullRecord struct {
+	StorageAccount string `json:"storageAccount"`
+	Container      string `json:"container"`
+	Blob           string `json:"blob"`
+	BytesWritten   int64  `json:"bytesWritten"`
+	Destination    string `json:"destination"`
+}
+
+func (cmd *SimpleCommand) pullBlobReport(name, localPath string, written int64) error {
+	rec := &pullRecord{
+		StorageAccount: cmd.config.Name,
+		Container:      cmd.source.Container,
+		Blob:           name,
+		BytesWritten:   written,
+		Destination:    localPath,
+	}
+
+	if cmd.records != nil {
+		return cmd.records.write(rec)
+	} else if cmd.outputMode == "json" {
+		s, _ := json.Marshal(rec)
 		cmd.logger.Info("%s\n", s)
 	}
+
+	return nil
 }
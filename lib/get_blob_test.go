@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestGetRecursiveStaysInDestination(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {
+			testBlob("../../escape.txt", 4),
+			testBlob("2016/../../up.txt", 4),
+			testBlob("2016/a.log", 3),
+		},
+	})
+	svc.contents = map[string]string{"logs/2016/a.log": "abc"}
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	base := c.MkDir()
+	dir := filepath.Join(base, "dst")
+
+	lg := &bufferLogger{}
+	cmd := &SimpleCommand{Command: "get", Recursive: true}
+	cmd.SetConfig(cfg)
+	cmd.SetLogger(lg)
+	cmd.AddSource(&BlobSpec{Container: "logs", PathPresent: true})
+	cmd.SetLocalPath(dir)
+	c.Assert(cmd.Dispatch(context.Background()), IsNil)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "2016", "a.log"))
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "abc")
+
+	for _, name := range []string{"../../escape.txt", "../up.txt"} {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		c.Assert(os.IsNotExist(err), Equals, true, Commentf("%s was written", name))
+	}
+	c.Assert(lg.String(), Equals, ""+
+		"Skipping logs/../../escape.txt: its name leads outside "+dir+"\n"+
+		"Skipping logs/2016/../../up.txt: its name leads outside "+dir+"\n")
+
+	for name, ok := range map[string]bool{"a/b.txt": true, "a/../b.txt": true, "..": false, "a/../..": false, "": false, "../x": false} {
+		_, got := localBlobPath("dst", name)
+		c.Assert(got, Equals, ok, Commentf("name %q", name))
+	}
+}
+
+func (s *S) TestGetRecursivePattern(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {
+			testBlob("2016/05/a.log", 1),
+			testBlob("2016/05/b.txt", 1),
+			testBlob("2016/c.log", 1),
+			testBlob("2017/d.log", 1),
+		},
+	})
+	svc.contents = map[string]string{
+		"logs/2016/05/a.log": "a",
+		"logs/2016/05/b.txt": "b",
+		"logs/2016/c.log":    "c",
+		"logs/2017/d.log":    "d",
+	}
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	dir := c.MkDir()
+	lg := &bufferLogger{}
+	cmd := &SimpleCommand{Command: "get"}
+	cmd.SetConfig(cfg)
+	cmd.SetLogger(lg)
+	cmd.SetOutputMode("json")
+	src, _ := ParseBlobSpec("logs/2016/**/*.log")
+	cmd.AddSource(src)
+	cmd.SetLocalPath(dir)
+	c.Assert(cmd.Dispatch(context.Background()), IsNil)
+
+	c.Assert(lg.String(), Matches, `\{.*"blob":"2016/05/a.log","bytesWritten":1,.*\}\n\{.*"blob":"2016/c.log",.*\}\n`)
+	for name, want := range map[string]bool{"2016/05/a.log": true, "2016/c.log": true, "2016/05/b.txt": false, "2017/d.log": false} {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		c.Assert(err == nil, Equals, want, Commentf("%s", name))
+	}
+}
@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+var (
+	ErrUnknownInventoryFormat = errors.New("unknown inventory format; expected csv or ndjson")
+)
+
+// InventoryCommand writes a record of every blob matching its sources to a
+// file, or with Subcommand "diff", compares two such files.  Taking an
+// inventory shares SizeCommand's workers, so containers are listed in
+// parallel.
+type InventoryCommand struct {
+	Subcommand string   // "" to take an inventory, or "diff"
+	Output     string   // the file to write; ending in .gz compresses it
+	Format     string   // csv or ndjson; by default, from Output's extension
+	Files      []string // diff: the older and newer inventories
+	SizeCommand
+}
+
+// inventoryRecord is a line of an inventory
+type inventoryRecord struct {
+	Container    string            `json:"container"`
+	Name         string            `json:"name"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"lastModified"`
+	Etag         string            `json:"etag"`
+	ContentType  string            `json:"contentType"`
+	ContentMD5   string            `json:"contentMD5"`
+	Metadata     map[string]string `json:"metadata"`
+}
+
+func newInventoryRecord(container string, u storage.Blob) *inventoryRecord {
+	b := newBlob(u)
+	return &inventoryRecord{
+		Container:    container,
+		Name:         b.Name,
+		Size:         b.ContentLength,
+		LastModified: b.LastModified,
+		Etag:         b.Etag,
+		ContentType:  b.ContentType,
+		ContentMD5:   u.Properties.ContentMD5,
+		Metadata:     u.Metadata,
+	}
+}
+
+func (cmd *InventoryCommand) Dispatch(ctx context.Context) error {
+	if cmd.Subcommand == "diff" {
+		return cmd.diff()
+	}
+
+	format, err := inventoryFormat(cmd.Output, cmd.Format)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(cmd.Output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// discard removes a partial inventory
+	discard := func() {
+		f.Close()
+		os.Remove(cmd.Output)
+	}
+
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(cmd.Output, ".gz") {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	bw := bufio.NewWriter(w)
+
+	out := &writerLogger{w: bw}
+	records, _ := newRecordWriter(format, out)
+	records.begin(&inventoryRecord{})
+
+	total := &sizeTotal{}
+	cmd.include = "metadata"
+	failures, err := cmd.scan(ctx, func(batch *sizeBatch) {
+		for _, u := range batch.listed {
+			if out.err == nil {
+				if err := records.write(newInventoryRecord(batch.container, u)); err != nil {
+					out.err = err
+				}
+			}
+			total.Size += u.Properties.ContentLength
+		}
+		total.Blobs += len(batch.blobs)
+	})
+	if err != nil {
+		discard()
+		return err
+	}
+	if out.err != nil {
+		discard()
+		return out.err
+	}
+
+	// An inventory that silently misses blobs is worse than none, so one
+	// that isn't reported is removed
+	cmd.whole = true
+	err = cmd.finishScan(ctx, total.Blobs, total.Size, failures, func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if zw != nil {
+			if err := zw.Close(); err != nil {
+				return err
+			}
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		cmd.inventoryReport(total, failures)
+		return nil
+	})
+	if err != nil && err != ErrIncompleteResult {
+		discard()
+		if err == ErrInterrupted {
+			cmd.logger.Info("Removed %s\n", cmd.Output)
+		}
+	}
+
+	return err
+}
+
+// inventoryFormat settles the format of an inventory file: as given, or
+// from its name
+func inventoryFormat(name, format string) (string, error) {
+	if format == "" {
+		format = "csv"
+		base := strings.TrimSuffix(name, ".gz")
+		if strings.HasSuffix(base, ".ndjson") || strings.HasSuffix(base, ".jsonl") {
+			format = "ndjson"
+		}
+	}
+
+	if format != "csv" && format != "ndjson" {
+		return "", ErrUnknownInventoryFormat
+	}
+
+	return format, nil
+}
+
+func (cmd *InventoryCommand) inventoryReport(total *sizeTotal, failures []*sizeError) {
+	if cmd.outputMode == "json" {
+		tmp := struct {
+			StorageAccount string       `json:"storageAccount"`
+			Output         string       `json:"output"`
+			Size           int64        `json:"size"`
+			Blobs          int          `json:"blobs"`
+			Errors         []*sizeError `json:"errors"`
+		}{
+			StorageAccount: cmd.config.Name,
+			Output:         cmd.Output,
+			Size:           total.Size,
+			Blobs:          total.Blobs,
+			Errors:         failures,
+		}
+
+		s, _ := json.Marshal(tmp)
+		cmd.logger.Info("%s\n", s)
+		return
+	}
+
+	cmd.logger.Info("Wrote %d blobs (%s) to %s\n", total.Blobs, formatSize(total.Size), cmd.Output)
+	reportFailures(cmd.logger, failures)
+}
@@ -0,0 +1,237 @@
+package lib
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inventoryChange is a blob that differs between two inventories
+type inventoryChange struct {
+	Blob   string   `json:"blob"`
+	Change string   `json:"change"`           // added, removed or changed
+	Fields []string `json:"fields,omitempty"` // for changed blobs, what changed
+}
+
+func (cmd *InventoryCommand) diff() error {
+	if len(cmd.Files) != 2 {
+		return ErrUnrecognizedCommand
+	}
+
+	older, err := readInventory(cmd.Files[0])
+	if err != nil {
+		return err
+	}
+	newer, err := readInventory(cmd.Files[1])
+	if err != nil {
+		return err
+	}
+
+	changes := diffInventories(older, newer)
+	cmd.diffReport(changes)
+
+	return nil
+}
+
+func diffInventories(older, newer map[string]*inventoryRecord) []*inventoryChange {
+	changes := []*inventoryChange{}
+	for key, a := range older {
+		b, ok := newer[key]
+		if !ok {
+			changes = append(changes, &inventoryChange{Blob: key, Change: "removed"})
+		} else if fields := changedFields(a, b); len(fields) > 0 {
+			changes = append(changes, &inventoryChange{Blob: key, Change: "changed", Fields: fields})
+		}
+	}
+	for key := range newer {
+		if _, ok := older[key]; !ok {
+			changes = append(changes, &inventoryChange{Blob: key, Change: "added"})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Blob < changes[j].Blob })
+
+	return changes
+}
+
+// changedFields names the columns that differ between two records of a blob
+func changedFields(a, b *inventoryRecord) []string {
+	fields := []string{}
+	va, vb := recordValues(a), recordValues(b)
+	for _, c := range recordColumns(reflect.TypeOf(a)) {
+		x, y := va[c.field], vb[c.field]
+		if tx, ok := x.(time.Time); ok {
+			if !tx.Equal(y.(time.Time)) {
+				fields = append(fields, c.name)
+			}
+			continue
+		}
+
+		// An empty map reads back as nil
+		if formatValue(x) != formatValue(y) {
+			fields = append(fields, c.name)
+		}
+	}
+
+	return fields
+}
+
+func (cmd *InventoryCommand) diffReport(changes []*inventoryChange) {
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Change]++
+	}
+
+	if cmd.outputMode == "json" {
+		tmp := struct {
+			Older   string             `json:"older"`
+			Newer   string             `json:"newer"`
+			Added   int                `json:"added"`
+			Removed int                `json:"removed"`
+			Changed int                `json:"changed"`
+			Changes []*inventoryChange `json:"changes"`
+		}{
+			Older:   cmd.Files[0],
+			Newer:   cmd.Files[1],
+			Added:   counts["added"],
+			Removed: counts["removed"],
+			Changed: counts["changed"],
+			Changes: changes,
+		}
+
+		s, _ := json.Marshal(tmp)
+		cmd.logger.Info("%s\n", s)
+		return
+	}
+
+	for _, c := range changes {
+		switch c.Change {
+		case "added":
+			cmd.logger.Info("+ %s\n", c.Blob)
+		case "removed":
+			cmd.logger.Info("- %s\n", c.Blob)
+		default:
+			cmd.logger.Info("~ %s (%s)\n", c.Blob, strings.Join(c.Fields, ", "))
+		}
+	}
+	cmd.logger.Info("%d added, %d removed, %d changed\n", counts["added"], counts["removed"], counts["changed"])
+}
+
+// readInventory loads an inventory file, in either format and compressed or
+// not, keyed by container/name
+func readInventory(path string) (map[string]*inventoryRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	read := readInventoryCSV
+	if first, _ := br.Peek(1); len(first) == 1 && first[0] == '{' {
+		read = readInventoryNDJSON
+	}
+
+	records := map[string]*inventoryRecord{}
+	err = read(br, func(rec *inventoryRecord) {
+		records[rec.Container+"/"+rec.Name] = rec
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return records, nil
+}
+
+func readInventoryNDJSON(r io.Reader, add func(*inventoryRecord)) error {
+	sc := bufio.NewScanner(r)
+	// metadata can make for long lines
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; sc.Scan(); line++ {
+		rec := &inventoryRecord{}
+		if err := json.Unmarshal(sc.Bytes(), rec); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		add(rec)
+	}
+
+	return sc.Err()
+}
+
+func readInventoryCSV(r io.Reader, add func(*inventoryRecord)) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"container", "name"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("missing %s column", name)
+		}
+	}
+
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+
+		rec := &inventoryRecord{
+			Container:   get("container"),
+			Name:        get("name"),
+			Etag:        get("etag"),
+			ContentType: get("contentType"),
+			ContentMD5:  get("contentMD5"),
+		}
+		if s := get("size"); s != "" {
+			if rec.Size, err = strconv.ParseInt(s, 10, 64); err != nil {
+				return fmt.Errorf("line %d: bad size %s", line, s)
+			}
+		}
+		if s := get("lastModified"); s != "" {
+			if rec.LastModified, err = time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("line %d: bad lastModified %s", line, s)
+			}
+		}
+		if s := get("metadata"); s != "" {
+			if err := json.Unmarshal([]byte(s), &rec.Metadata); err != nil {
+				return fmt.Errorf("line %d: bad metadata %s", line, s)
+			}
+		}
+
+		add(rec)
+	}
+}
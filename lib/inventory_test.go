@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestInventory(c *C) {
+	report := testBlob("report.pdf", 2000)
+	report.Properties.LastModified = "Tue, 03 May 2016 10:00:00 GMT"
+	report.Properties.ContentMD5 = "1B2M2Y8AsgTpgAmY7PhCfg=="
+	report.Metadata = storage.BlobMetadata{"owner": "finance"}
+
+	svc := newBlobService(map[string][]storage.Blob{
+		"docs": {report, testBlob("notes.txt", 10)},
+		"logs": {testBlob("a.log", 100)},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	dir := c.MkDir()
+	take := func(name string) string {
+		lg := &bufferLogger{}
+		cmd := &InventoryCommand{Output: filepath.Join(dir, name)}
+		cmd.SetConfig(cfg)
+		cmd.SetLogger(lg)
+		cmd.SetWorkers(2)
+		cmd.AddSource(&BlobSpec{})
+		c.Assert(cmd.Dispatch(context.Background()), IsNil)
+		return lg.String()
+	}
+
+	c.Assert(take("before.csv.gz"), Equals, "Wrote 3 blobs (2.11 KB) to "+filepath.Join(dir, "before.csv.gz")+"\n")
+
+	records, err := readInventory(filepath.Join(dir, "before.csv.gz"))
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 3)
+	rec := records["docs/report.pdf"]
+	c.Assert(rec.Size, Equals, int64(2000))
+	c.Assert(rec.ContentMD5, Equals, "1B2M2Y8AsgTpgAmY7PhCfg==")
+	c.Assert(rec.Metadata, DeepEquals, map[string]string{"owner": "finance"})
+	c.Assert(rec.LastModified.IsZero(), Equals, false)
+
+	// Add, remove and change a blob, then compare across formats
+	report.Metadata = storage.BlobMetadata{"owner": "legal"}
+	svc.containers["docs"] = []storage.Blob{report, testBlob("todo.txt", 5)}
+	svc.containers["logs"] = []storage.Blob{testBlob("a.log", 150)}
+	take("after.ndjson")
+
+	lg := &bufferLogger{}
+	diff := &InventoryCommand{Subcommand: "diff", Files: []string{filepath.Join(dir, "before.csv.gz"), filepath.Join(dir, "after.ndjson")}}
+	diff.SetLogger(lg)
+	c.Assert(diff.Dispatch(context.Background()), IsNil)
+	c.Assert(lg.String(), Equals, ""+
+		"- docs/notes.txt\n"+
+		"~ docs/report.pdf (metadata)\n"+
+		"+ docs/todo.txt\n"+
+		"~ logs/a.log (size)\n"+
+		"1 added, 1 removed, 2 changed\n")
+
+	_, err = inventoryFormat("inventory.txt", "")
+	c.Assert(err, IsNil)
+	_, err = inventoryFormat("x.csv", "yaml")
+	c.Assert(err, Equals, ErrUnknownInventoryFormat)
+
+	// Even as JSON, a source that can't be listed leaves no inventory
+	failed := &InventoryCommand{Output: filepath.Join(dir, "failed.csv")}
+	failed.SetConfig(cfg)
+	failed.SetLogger(&bufferLogger{})
+	failed.SetOutputMode("json")
+	failed.SetWorkers(2)
+	failed.AddSource(&BlobSpec{Container: "docs", PathPresent: true})
+	failed.AddSource(&BlobSpec{Container: "missing", PathPresent: true})
+	c.Assert(failed.Dispatch(context.Background()), Equals, ErrContainerNotFound)
+	_, err = os.Stat(filepath.Join(dir, "failed.csv"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
@@ -1,6 +1,9 @@
 package lib
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+)
 
 type Logger interface {
 	Info(string, ...interface{})
@@ -30,3 +33,19 @@ func (lg stdoutLogger) Debug(format string, args ...interface{}) {
 		fmt.Printf(format, args...)
 	}
 }
+
+// writerLogger sends Info messages to a writer, such as a file, and drops
+// Debug messages.  The first write to fail is kept in err, and stops any
+// more.
+type writerLogger struct {
+	w   io.Writer
+	err error
+}
+
+func (lg *writerLogger) Info(format string, args ...interface{}) {
+	if lg.err == nil {
+		_, lg.err = fmt.Fprintf(lg.w, format, args...)
+	}
+}
+
+func (lg *writerLogger) Debug(format string, args ...interface{}) {}
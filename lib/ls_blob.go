@@ -2,6 +2,7 @@ package lib
 
 import (
 	"encoding/json"
+	"sort"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/storage"
@@ -29,69 +30,245 @@ func newBlob(c storage.Blob) *blob {
 
 func (cmd *SimpleCommand) listBlobs() error {
 	// get the client
-	client, err := cmd.config.getBlobStorageClient()
+	client, err := cmd.config.getBlobStorageClient(cmd.ctx)
 	if err != nil {
 		return err
 	}
 
-	arr, err := cmd.listBlobsInternal(client)
+	// Like a filesystem ls, list a single level unless asked to recurse.  A
+	// pattern says for itself how deep to look.
+	delimiter := "/"
+	if cmd.Recursive || cmd.source.Pattern != nil {
+		delimiter = ""
+	}
+
+	if cmd.streaming() {
+		return cmd.streamBlobs(client, delimiter)
+	}
+
+	arr, prefixes, err := cmd.listBlobsDelimited(client, delimiter)
 	if err != nil {
 		return err
 	}
 
-	cmd.listBlobsReport(arr)
+	if err := sortBlobs(arr, cmd.SortBy, cmd.Reverse); err != nil {
+		return err
+	}
 
-	return nil
+	return cmd.listBlobsReport(arr, prefixes)
 }
 
-func (cmd *SimpleCommand) listBlobsReport(arr []*blob) {
+func (cmd *SimpleCommand) listBlobsReport(arr []*blob, prefixes []string) error {
 	if cmd.outputMode == "json" {
 		tmp := struct {
-			StorageAccount string  `json:"storageAccount"`
-			Container      string  `json:"container"`
-			Blobs          []*blob `json:"blobs"`
+			StorageAccount string   `json:"storageAccount"`
+			Container      string   `json:"container"`
+			Prefixes       []string `json:"prefixes"`
+			Blobs          []*blob  `json:"blobs"`
+			NextMarker     string   `json:"nextMarker,omitempty"`
 		}{
 			StorageAccount: cmd.config.Name,
 			Container:      cmd.source.Container,
+			Prefixes:       prefixes,
 			Blobs:          arr,
+			NextMarker:     cmd.nextMarker,
 		}
 
 		s, _ := json.Marshal(tmp)
 		cmd.logger.Info("%s\n", s)
+		return nil
+	}
+
+	if cmd.LongFormat && cmd.records == nil {
+		cmd.listBlobsLong(arr, prefixes)
 	} else {
-		for _, u := range arr {
-			cmd.logger.Info("%s\n", u.Name)
+		if cmd.records != nil {
+			cmd.records.begin(&blob{}, &prefixRecord{})
 		}
-		cmd.logger.Debug("Found %d blobs\n", len(arr))
+		for _, l := range orderListing(arr, prefixes, cmd.SortBy, cmd.Reverse) {
+			if err := cmd.printListing(l); err != nil {
+				return err
+			}
+		}
+		cmd.logger.Debug("Found %d prefixes and %d blobs\n", len(prefixes), len(arr))
 	}
+
+	cmd.printNextMarker()
+	return nil
 }
 
-func (cmd *SimpleCommand) listBlobsInternal(client *storage.BlobStorageClient) ([]*blob, error) {
-	// query the endpoint
-	params := storage.ListBlobsParameters{Prefix: cmd.source.Path}
-	res, err := client.ListBlobs(cmd.source.Container, params)
+// printNextMarker says where to resume a listing cut short by MaxResults
+func (cmd *SimpleCommand) printNextMarker() {
+	if cmd.nextMarker == "" {
+		return
+	}
+
+	if cmd.Format == "ndjson" {
+		s, _ := json.Marshal(struct {
+			NextMarker string `json:"nextMarker"`
+		}{cmd.nextMarker})
+		cmd.logger.Info("%s\n", s)
+	} else {
+		cmd.logger.Info("Next marker: %s\n", cmd.nextMarker)
+	}
+}
+
+// streaming reports whether ls can print each page as it arrives.  The
+// service lists in name order, so only other orders, long format's aligned
+// columns and whole-document JSON need the full listing first.
+func (cmd *SimpleCommand) streaming() bool {
+	sorted := (cmd.SortBy == "" || cmd.SortBy == "name") && !cmd.Reverse
+	switch {
+	case cmd.outputMode == "json":
+		return false
+	case cmd.records != nil:
+		return sorted
+	default:
+		return sorted && !cmd.LongFormat
+	}
+}
+
+func (cmd *SimpleCommand) streamBlobs(client *storage.BlobStorageClient, delimiter string) error {
+	if cmd.records != nil {
+		cmd.records.begin(&blob{}, &prefixRecord{})
+	}
+
+	blobs, prefixes := 0, 0
+	err := cmd.eachBlobPage(client, delimiter, func(arr []*blob, pre []string) error {
+		for _, l := range orderListing(arr, pre, "name", false) {
+			if err := cmd.printListing(l); err != nil {
+				return err
+			}
+		}
+		blobs += len(arr)
+		prefixes += len(pre)
+		return nil
+	})
 	if err != nil {
-		return nil, handleListError(err)
+		return err
+	}
+
+	cmd.logger.Debug("Found %d prefixes and %d blobs\n", prefixes, blobs)
+	cmd.printNextMarker()
+	return nil
+}
+
+// prefixRecord is a prefix as --format writes it
+type prefixRecord struct {
+	Name   string `json:"name"`
+	Prefix bool   `json:"prefix"`
+}
+
+// printListing prints a line of ls output: the name, or a record in the
+// chosen --format
+func (cmd *SimpleCommand) printListing(l listing) error {
+	switch {
+	case cmd.records == nil:
+		cmd.logger.Info("%s\n", l.name())
+		return nil
+	case l.blob != nil:
+		return cmd.records.write(l.blob)
+	default:
+		return cmd.records.write(&prefixRecord{l.prefix, true})
 	}
+}
+
+// listing is a line of ls output: either a prefix or a blob
+type listing struct {
+	prefix string
+	blob   *blob
+}
+
+func (l listing) name() string {
+	if l.blob != nil {
+		return l.blob.Name
+	}
+	return l.prefix
+}
+
+// orderListing interleaves prefixes with sorted blobs, as directories are
+// with files.  Prefixes have no size or time, so unless sorting by name they
+// come first.
+func orderListing(arr []*blob, prefixes []string, by string, reverse bool) []listing {
+	out := []listing{}
+	for _, p := range prefixes {
+		out = append(out, listing{prefix: p})
+	}
+	for _, u := range arr {
+		out = append(out, listing{blob: u})
+	}
+
+	if by == "" || by == "name" {
+		sort.SliceStable(out, func(i, j int) bool {
+			if reverse {
+				return out[j].name() < out[i].name()
+			}
+			return out[i].name() < out[j].name()
+		})
+	}
+
+	return out
+}
 
+func (cmd *SimpleCommand) listBlobsInternal(client *storage.BlobStorageClient) ([]*blob, error) {
+	arr, _, err := cmd.listBlobsDelimited(client, "")
+	return arr, err
+}
+
+// listBlobsDelimited lists the blobs under the source path, or matching its
+// pattern.  Given a delimiter, blobs beyond the next one are rolled up into
+// prefixes, much like subdirectories.
+func (cmd *SimpleCommand) listBlobsDelimited(client *storage.BlobStorageClient, delimiter string) ([]*blob, []string, error) {
 	arr := []*blob{}
-	for _, u := range res.Blobs {
-		arr = append(arr, newBlob(u))
+	prefixes := []string{}
+	err := cmd.eachBlobPage(client, delimiter, func(blobs []*blob, pre []string) error {
+		arr = append(arr, blobs...)
+		prefixes = append(prefixes, pre...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	for res.NextMarker != "" {
-		params.Marker = res.NextMarker
-		res, err = client.ListBlobs(cmd.source.Container, params)
+	return arr, prefixes, nil
+}
+
+// eachBlobPage walks the listing a page at a time, so callers can act on
+// blobs as they arrive.  An error from fn stops the walk.  The walk starts
+// at cmd.Marker and, given cmd.MaxResults, stops after that many entries,
+// leaving the marker to resume from in cmd.nextMarker.
+func (cmd *SimpleCommand) eachBlobPage(client *storage.BlobStorageClient, delimiter string, fn func(blobs []*blob, prefixes []string) error) error {
+	// query the endpoint
+	params := storage.ListBlobsParameters{Prefix: cmd.source.Path, Delimiter: delimiter, Marker: cmd.Marker}
+
+	remaining := cmd.MaxResults
+	for {
+		if cmd.MaxResults > 0 {
+			params.MaxResults = uint(remaining)
+		}
+
+		res, err := client.ListBlobs(cmd.source.Container, params)
 		if err != nil {
-			return nil, handleListError(err)
+			return handleListError(interrupted(cmd.ctx, err))
 		}
 
+		blobs := []*blob{}
 		for _, u := range res.Blobs {
-			arr = append(arr, newBlob(u))
+			if b := newBlob(u); cmd.source.Selects(b) {
+				blobs = append(blobs, b)
+			}
+		}
+		if err := fn(blobs, res.BlobPrefixes); err != nil {
+			return err
 		}
-	}
 
-	return arr, nil
+		cmd.nextMarker = res.NextMarker
+		remaining -= len(res.Blobs) + len(res.BlobPrefixes)
+		if res.NextMarker == "" || (cmd.MaxResults > 0 && remaining <= 0) {
+			return nil
+		}
+		params.Marker = res.NextMarker
+	}
 }
 
 func handleListError(err error) error {
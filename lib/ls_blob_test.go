@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestListBlobsDelimited(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {
+			testBlob("2016/05/a.log", 1),
+			testBlob("2016/b.log", 1),
+			testBlob("2017/c.log", 1),
+			testBlob("README", 1),
+		},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls"}, "logs/", "bare"), Equals, "2016/\n2017/\nREADME\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls"}, "logs/2016/", "bare"), Equals, "2016/05/\n2016/b.log\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", Recursive: true}, "logs/2016/", "bare"), Equals, "2016/05/a.log\n2016/b.log\n")
+
+	// Patterns list as deep as they need to
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls"}, "logs/20*/*.log", "bare"), Equals, "2016/b.log\n2017/c.log\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls"}, "logs/**.log", "bare"), Equals, "2016/05/a.log\n2016/b.log\n2017/c.log\n")
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls"}, "logs/2016/", "json"), Matches, `.*"prefixes":\["2016/05/"\],"blobs":\[\{"name":"2016/b.log".*\n`)
+}
+
+func (s *S) TestListBlobsLong(c *C) {
+	big := testBlob("logs/big.log", 2500)
+	big.Properties.LastModified = "Tue, 03 May 2016 10:00:00 GMT"
+	big.Properties.ContentType = "text/plain"
+	big.Properties.Etag = "0x1"
+	small := testBlob("small.txt", 10)
+	small.Properties.LastModified = "Wed, 04 May 2016 10:00:00 GMT"
+	small.Properties.Etag = "0x2"
+
+	svc := newBlobService(map[string][]storage.Blob{"www": {big, small}})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", LongFormat: true, Recursive: true, SortBy: "size"}, "www/", ""), Equals, ""+
+		"2500  2016-05-03 10:00:00  text/plain  0x1  logs/big.log\n"+
+		"  10  2016-05-04 10:00:00  -           0x2  small.txt\n")
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", LongFormat: true, HumanSizes: true, SortBy: "name", Reverse: true}, "www/", ""), Equals, ""+
+		"10 B  2016-05-04 10:00:00  -  0x2  small.txt\n"+
+		"   -  -                    -  -    logs/\n")
+
+	// Prefixes have no time, so go first
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", SortBy: "time"}, "www/", ""), Equals, "logs/\nsmall.txt\n")
+}
+
+func (s *S) TestListBlobsStreaming(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {
+			testBlob("2016/05/a.log", 1),
+			testBlob("2016/b.log", 2),
+			testBlob("2017/c.log", 3),
+			testBlob("README", 4),
+		},
+		"www": nil,
+	})
+	svc.pageSize = 1
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls"}, "logs/", "bare"), Equals, "2016/\n2017/\nREADME\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", Format: "ndjson"}, "logs/2016/", "bare"), Matches,
+		`\{"name":"2016/05/","prefix":true\}\n\{"name":"2016/b.log",.*"contentLength":2,.*\}\n`)
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls"}, "", "bare"), Equals, "logs\nwww\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", Format: "ndjson"}, "", "bare"), Matches, `\{"name":"logs",.*\}\n\{"name":"www",.*\}\n`)
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls"}, "logs", "bare"), Equals, "2016/\n2017/\nREADME\n")
+
+	// Other orders still need every page first
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", Format: "ndjson", Recursive: true, SortBy: "size"}, "logs/", "bare"), Matches,
+		`\{"name":"README",.*\}\n\{"name":"2017/c.log",.*\}\n.*\n.*\n`)
+}
+
+func (s *S) TestListBlobsMarker(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {testBlob("a.log", 1), testBlob("b.log", 1), testBlob("c.log", 1)},
+		"www":  nil,
+	})
+	svc.pageSize = 2
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	// Page through across invocations
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", MaxResults: 1}, "logs/", "bare"), Equals, "a.log\nNext marker: 1\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", MaxResults: 1, Marker: "1"}, "logs/", "bare"), Equals, "b.log\nNext marker: 2\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", Marker: "2"}, "logs/", "bare"), Equals, "c.log\n")
+
+	// Limits beyond a page span several requests
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", MaxResults: 3}, "logs/", "bare"), Equals, "a.log\nb.log\nc.log\n")
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", MaxResults: 2}, "logs/", "json"), Matches, `.*"blobs":\[.*\],"nextMarker":"2"\}\n`)
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", Format: "ndjson", MaxResults: 2}, "logs/", "bare"), Matches, `(?s).*\n\{"nextMarker":"2"\}\n`)
+
+	// A container's own name is still a direct match, with the marker for its blobs
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", Marker: "1"}, "logs", "bare"), Equals, "b.log\nc.log\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", MaxResults: 1}, "", "bare"), Equals, "logs\nNext marker: 1\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "ls", Marker: "1"}, "", "json"), Matches, `.*"containers":\[\{"name":"www".*\]\}\n`)
+}
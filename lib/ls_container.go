@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
 	"time"
@@ -30,77 +31,175 @@ func newContainer(c storage.Container) *container {
 
 func (cmd *SimpleCommand) listContainers() error {
 	// get the client
-	client, err := cmd.config.getBlobStorageClient()
+	client, err := cmd.config.getBlobStorageClient(cmd.ctx)
 	if err != nil {
 		return err
 	}
 
-	arr, err := listContainersInternal(client, cmd.source.Container)
+	// A marker resumes the listing it came from, so settle whether this is a
+	// container's blobs before paging through containers
+	if cmd.source.Container != "" && (cmd.Marker != "" || cmd.MaxResults > 0) {
+		arr, err := listContainersInternal(cmd.ctx, client, cmd.source.Container)
+		if err != nil {
+			return err
+		}
+		if len(arr) == 1 && cmd.source.Container == arr[0].Name {
+			return cmd.listBlobs()
+		}
+	}
+
+	if cmd.streaming() {
+		return cmd.streamContainers(client)
+	}
+
+	arr := []*container{}
+	err = cmd.eachContainerPage(client, func(page []*container, _ bool) error {
+		arr = append(arr, page...)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
 	// list blobs if there was a direct match on the container
-	if len(arr) == 1 && cmd.source.Container == arr[0].Name {
+	if len(arr) == 1 && cmd.source.Container == arr[0].Name && cmd.nextMarker == "" {
 		return cmd.listBlobs()
 	}
 
-	listContainersReport(arr, cmd)
+	if err := sortContainers(arr, cmd.SortBy, cmd.Reverse); err != nil {
+		return err
+	}
+
+	if cmd.LongFormat && cmd.outputMode != "json" && cmd.records == nil {
+		cmd.listContainersLong(arr)
+		return nil
+	}
 
-	return nil
+	return cmd.listContainersReport(arr)
 }
 
-func listContainersInternal(client *storage.BlobStorageClient, namePrefix string) ([]*container, error) {
-	// query the endpoint
-	params := storage.ListContainersParameters{
-		Prefix: namePrefix,
+func (cmd *SimpleCommand) streamContainers(client *storage.BlobStorageClient) error {
+	count, direct := 0, false
+	err := cmd.eachContainerPage(client, func(arr []*container, last bool) error {
+		// list blobs if there was a direct match on the container
+		if count == 0 && last && len(arr) == 1 && cmd.source.Container == arr[0].Name {
+			direct = true
+			return cmd.listBlobs()
+		}
+
+		for _, u := range arr {
+			if err := cmd.printContainer(u); err != nil {
+				return err
+			}
+		}
+		count += len(arr)
+		return nil
+	})
+	if err != nil || direct {
+		return err
 	}
-	res, err := client.ListContainers(params)
+
+	cmd.logger.Debug("Found %d containers\n", count)
+	cmd.printNextMarker()
+	return nil
+}
+
+// eachContainerPage walks the containers matching the source, starting at
+// cmd.Marker and stopping after cmd.MaxResults if set
+func (cmd *SimpleCommand) eachContainerPage(client *storage.BlobStorageClient, fn func(arr []*container, last bool) error) error {
+	params := storage.ListContainersParameters{Prefix: cmd.source.Container, Marker: cmd.Marker}
+
+	var err error
+	cmd.nextMarker, err = eachContainerPage(cmd.ctx, client, params, cmd.MaxResults, fn)
+	return err
+}
+
+func listContainersInternal(ctx context.Context, client *storage.BlobStorageClient, namePrefix string) ([]*container, error) {
+	arr := []*container{}
+	params := storage.ListContainersParameters{Prefix: namePrefix}
+	_, err := eachContainerPage(ctx, client, params, 0, func(page []*container, _ bool) error {
+		arr = append(arr, page...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// flatten results
-	arr := []*container{}
-	for _, u := range res.Containers {
-		if strings.HasPrefix(u.Name, namePrefix) {
-			arr = append(arr, newContainer(u))
+	return arr, nil
+}
+
+// eachContainerPage walks the containers a page at a time, telling fn
+// whether the page is the last there is.  An error from fn stops the walk.
+// Given a limit, it stops after that many containers and returns the marker
+// to resume from.
+func eachContainerPage(ctx context.Context, client *storage.BlobStorageClient, params storage.ListContainersParameters,
+	limit int, fn func(arr []*container, last bool) error) (string, error) {
+
+	remaining := limit
+	for {
+		if limit > 0 {
+			params.MaxResults = uint(remaining)
 		}
-	}
 
-	for res.NextMarker != "" {
-		params.Marker = res.NextMarker
-		res, err = client.ListContainers(params)
+		// query the endpoint
+		res, err := client.ListContainers(params)
 		if err != nil {
-			return nil, handleListError(err)
+			return "", handleListError(interrupted(ctx, err))
 		}
 
+		arr := []*container{}
 		for _, u := range res.Containers {
-			if strings.HasPrefix(u.Name, namePrefix) {
+			if strings.HasPrefix(u.Name, params.Prefix) {
 				arr = append(arr, newContainer(u))
 			}
 		}
-	}
 
-	return arr, nil
+		if err := fn(arr, res.NextMarker == ""); err != nil {
+			return "", err
+		}
+
+		remaining -= len(res.Containers)
+		if res.NextMarker == "" || (limit > 0 && remaining <= 0) {
+			return res.NextMarker, nil
+		}
+		params.Marker = res.NextMarker
+	}
 }
 
-func listContainersReport(arr []*container, cmd Command) {
-	if cmd.OutputMode() == "json" {
+func (cmd *SimpleCommand) listContainersReport(arr []*container) error {
+	if cmd.outputMode == "json" {
 		tmp := struct {
 			StorageAccount string       `json:"storageAccount"`
 			Containers     []*container `json:"containers"`
+			NextMarker     string       `json:"nextMarker,omitempty"`
 		}{
-			StorageAccount: cmd.Config().Name,
+			StorageAccount: cmd.config.Name,
 			Containers:     arr,
+			NextMarker:     cmd.nextMarker,
 		}
 
 		s, _ := json.Marshal(tmp)
-		cmd.Logger().Info("%s\n", s)
-	} else {
-		for _, u := range arr {
-			cmd.Logger().Info("%s\n", u.Name)
+		cmd.logger.Info("%s\n", s)
+		return nil
+	}
+
+	for _, u := range arr {
+		if err := cmd.printContainer(u); err != nil {
+			return err
 		}
-		cmd.Logger().Debug("Found %d containers\n", len(arr))
 	}
+	cmd.logger.Debug("Found %d containers\n", len(arr))
+	cmd.printNextMarker()
+	return nil
+}
+
+// printContainer prints the container's name, or a record in the chosen
+// --format
+func (cmd *SimpleCommand) printContainer(u *container) error {
+	if cmd.records != nil {
+		return cmd.records.write(u)
+	}
+
+	cmd.logger.Info("%s\n", u.Name)
+	return nil
 }
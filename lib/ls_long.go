@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownSortKey = errors.New("unknown sort order; expected name, size or time")
+)
+
+const longTimeFormat = "2006-01-02 15:04:05"
+
+// sortBlobs orders blobs by name, size (largest first) or time (newest
+// first), as ls does
+func sortBlobs(arr []*blob, by string, reverse bool) error {
+	var less func(a, b *blob) bool
+	switch by {
+	case "", "name":
+		less = func(a, b *blob) bool { return a.Name < b.Name }
+	case "size":
+		less = func(a, b *blob) bool { return a.ContentLength > b.ContentLength }
+	case "time":
+		less = func(a, b *blob) bool { return a.LastModified.After(b.LastModified) }
+	default:
+		return ErrUnknownSortKey
+	}
+
+	sort.SliceStable(arr, func(i, j int) bool {
+		if reverse {
+			return less(arr[j], arr[i])
+		}
+		return less(arr[i], arr[j])
+	})
+
+	return nil
+}
+
+// sortContainers orders containers by name or time.  Containers have no
+// size, so sorting by size leaves them by name.
+func sortContainers(arr []*container, by string, reverse bool) error {
+	var less func(a, b *container) bool
+	switch by {
+	case "", "name", "size":
+		less = func(a, b *container) bool { return a.Name < b.Name }
+	case "time":
+		less = func(a, b *container) bool { return a.LastModified.After(b.LastModified) }
+	default:
+		return ErrUnknownSortKey
+	}
+
+	sort.SliceStable(arr, func(i, j int) bool {
+		if reverse {
+			return less(arr[j], arr[i])
+		}
+		return less(arr[i], arr[j])
+	})
+
+	return nil
+}
+
+func (cmd *SimpleCommand) listBlobsLong(arr []*blob, prefixes []string) {
+	rows := [][]string{}
+	for _, l := range orderListing(arr, prefixes, cmd.SortBy, cmd.Reverse) {
+		if l.blob == nil {
+			rows = append(rows, []string{"-", "-", "-", "-", l.prefix})
+			continue
+		}
+
+		u := l.blob
+		size := fmt.Sprintf("%d", u.ContentLength)
+		if cmd.HumanSizes {
+			size = formatSize(u.ContentLength)
+		}
+
+		rows = append(rows, []string{size, longTime(u.LastModified), orDash(u.ContentType), u.Etag, u.Name})
+	}
+
+	for _, line := range formatColumns(rows, []bool{true}) {
+		cmd.logger.Info("%s\n", line)
+	}
+	cmd.logger.Debug("Found %d prefixes and %d blobs\n", len(prefixes), len(arr))
+}
+
+func (cmd *SimpleCommand) listContainersLong(arr []*container) {
+	rows := [][]string{}
+	for _, u := range arr {
+		rows = append(rows, []string{longTime(u.LastModified), orDash(u.LeaseState), orDash(u.LeaseStatus), u.Etag, u.Name})
+	}
+
+	for _, line := range formatColumns(rows, nil) {
+		cmd.logger.Info("%s\n", line)
+	}
+	cmd.logger.Debug("Found %d containers\n", len(arr))
+}
+
+func longTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(longTimeFormat)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatColumns pads each column of rows to the width of its widest cell.
+// Columns flagged in rightAlign are aligned right, for numbers; the last
+// column is never padded.
+func formatColumns(rows [][]string, rightAlign []bool) []string {
+	widths := []int{}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	lines := []string{}
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			pad := strings.Repeat(" ", widths[i]-len(cell))
+			switch {
+			case i < len(rightAlign) && rightAlign[i]:
+				cells[i] = pad + cell
+			case i == len(row)-1:
+				cells[i] = cell
+			default:
+				cells[i] = cell + pad
+			}
+		}
+		lines = append(lines, strings.Join(cells, "  "))
+	}
+
+	return lines
+}
@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// recordWriter prints records, such as blobs or containers, one at a time
+// in the format given by --format: csv, tsv, ndjson, yaml, or otherwise a
+// text/template applied to each record.  Reports hand it their records
+// rather than formatting them themselves.
+type recordWriter struct {
+	format  string
+	tmpl    *template.Template
+	logger  Logger
+	columns []recordColumn
+	header  bool
+}
+
+// recordColumn is a field of the records being written
+type recordColumn struct {
+	field string // the Go name, for templates
+	name  string // the JSON name, for headers and keys
+}
+
+func newRecordWriter(format string, logger Logger) (*recordWriter, error) {
+	w := &recordWriter{format: format, logger: logger}
+
+	switch format {
+	case "csv", "tsv", "ndjson", "yaml":
+	default:
+		// Shells don't expand \t and \n in quotes, so do it here
+		text := strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(format)
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+
+		tmpl, err := template.New("format").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --format template: %s", err)
+		}
+		w.format, w.tmpl = "template", tmpl
+	}
+
+	return w, nil
+}
+
+// begin sets the columns from protos, one record of each kind to be
+// written.  Records leave the columns they lack empty.  Without it, the
+// first record sets the columns.
+func (w *recordWriter) begin(protos ...interface{}) {
+	w.columns = []recordColumn{}
+	seen := map[string]bool{}
+	for _, proto := range protos {
+		for _, c := range recordColumns(reflect.TypeOf(proto)) {
+			if !seen[c.field] {
+				w.columns = append(w.columns, c)
+				seen[c.field] = true
+			}
+		}
+	}
+	w.header = false
+}
+
+func (w *recordWriter) write(rec interface{}) error {
+	if w.columns == nil {
+		w.begin(rec)
+	}
+
+	switch w.format {
+	case "ndjson":
+		s, _ := json.Marshal(rec)
+		w.logger.Info("%s\n", s)
+	case "csv", "tsv":
+		if !w.header {
+			names := []string{}
+			for _, c := range w.columns {
+				names = append(names, c.name)
+			}
+			w.writeRow(names)
+			w.header = true
+		}
+
+		values := recordValues(rec)
+		row := []string{}
+		for _, c := range w.columns {
+			row = append(row, formatValue(values[c.field]))
+		}
+		w.writeRow(row)
+	case "yaml":
+		values := recordValues(rec)
+		for i, c := range recordColumns(reflect.TypeOf(rec)) {
+			s, _ := json.Marshal(values[c.field])
+			lead := "  "
+			if i == 0 {
+				lead = "- "
+			}
+			w.logger.Info("%s%s: %s\n", lead, c.name, s)
+		}
+	case "template":
+		// Fill in the columns a record lacks, so one template serves them all
+		values := recordValues(rec)
+		for _, c := range w.columns {
+			if _, ok := values[c.field]; !ok {
+				values[c.field] = ""
+			}
+		}
+
+		var b bytes.Buffer
+		if err := w.tmpl.Execute(&b, values); err != nil {
+			return fmt.Errorf("invalid --format template: %s", err)
+		}
+		w.logger.Info("%s", b.String())
+	}
+
+	return nil
+}
+
+func (w *recordWriter) writeRow(row []string) {
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	if w.format == "tsv" {
+		cw.Comma = '\t'
+	}
+	cw.Write(row)
+	cw.Flush()
+	w.logger.Info("%s", b.String())
+}
+
+// recordColumns lists the JSON-tagged fields of a struct type
+func recordColumns(t reflect.Type) []recordColumn {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	columns := []recordColumn{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || name == "" || name == "-" {
+			continue
+		}
+		columns = append(columns, recordColumn{f.Name, name})
+	}
+
+	return columns
+}
+
+// recordValues maps the Go names of a record's fields to their values
+func recordValues(rec interface{}) map[string]interface{} {
+	v := reflect.Indirect(reflect.ValueOf(rec))
+
+	values := map[string]interface{}{}
+	for _, c := range recordColumns(v.Type()) {
+		values[c.field] = v.FieldByName(c.field).Interface()
+	}
+
+	return values
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case map[string]string:
+		if len(v) == 0 {
+			return ""
+		}
+		s, _ := json.Marshal(v)
+		return string(s)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestListFormats(c *C) {
+	b := testBlob("2016/b.log", 2500)
+	b.Properties.LastModified = "Tue, 03 May 2016 10:00:00 GMT"
+	b.Properties.ContentType = "text/plain"
+
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {testBlob("2016/05/a.log", 1), b},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	run := func(format string, cmd *SimpleCommand) string {
+		lg := &bufferLogger{}
+		if cmd.Command == "" {
+			cmd.Command = "ls"
+		}
+		cmd.Format = format
+		cmd.SetConfig(cfg)
+		cmd.SetLogger(lg)
+		if cmd.Command == "tree" {
+			cmd.AddSource(&BlobSpec{Container: "logs"})
+		} else {
+			cmd.AddSource(&BlobSpec{Container: "logs", Path: "2016/", PathPresent: true})
+		}
+		c.Assert(cmd.Dispatch(context.Background()), IsNil)
+		return lg.String()
+	}
+
+	// Prefixes leave the columns of blobs empty
+	c.Assert(run("csv", &SimpleCommand{}), Equals, ""+
+		"name,lastModified,etag,contentLength,contentType,contentEncoding,prefix\n"+
+		"2016/05/,,,,,,true\n"+
+		"2016/b.log,2016-05-03T10:00:00Z,,2500,text/plain,,\n")
+	c.Assert(run("tsv", &SimpleCommand{Recursive: true, SortBy: "size"}), Equals, ""+
+		"name\tlastModified\tetag\tcontentLength\tcontentType\tcontentEncoding\tprefix\n"+
+		"2016/b.log\t2016-05-03T10:00:00Z\t\t2500\ttext/plain\t\t\n"+
+		"2016/05/a.log\t\t\t1\t\t\t\n")
+	c.Assert(run(`{{.Name}}\t{{.ContentLength}}`, &SimpleCommand{}), Equals, "2016/05/\t\n2016/b.log\t2500\n")
+	c.Assert(run(`{{if .Prefix}}d{{else}}-{{end}} {{.Name}}`, &SimpleCommand{}), Equals, "d 2016/05/\n- 2016/b.log\n")
+	c.Assert(run("yaml", &SimpleCommand{}), Equals, ""+
+		"- name: \"2016/05/\"\n"+
+		"  prefix: true\n"+
+		"- name: \"2016/b.log\"\n"+
+		"  lastModified: \"2016-05-03T10:00:00Z\"\n"+
+		"  etag: \"\"\n"+
+		"  contentLength: 2500\n"+
+		"  contentType: \"text/plain\"\n"+
+		"  contentEncoding: \"\"\n")
+
+	// tree lists the blobs themselves
+	c.Assert(run("{{.Name}}", &SimpleCommand{Command: "tree"}), Equals, "2016/05/a.log\n2016/b.log\n")
+
+	_, err := newRecordWriter("{{.Name", &bufferLogger{})
+	c.Assert(err, ErrorMatches, "invalid --format template: .*")
+	cmd := &SimpleCommand{Command: "ls", Format: "{{.Missing}}"}
+	cmd.SetConfig(cfg)
+	cmd.SetLogger(&bufferLogger{})
+	cmd.AddSource(&BlobSpec{Container: "logs", PathPresent: true})
+	c.Assert(cmd.Dispatch(context.Background()), ErrorMatches, "invalid --format template: .*")
+}
@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io"
 	"os"
@@ -21,8 +22,7 @@ func (cmd *SimpleCommand) putBlob() error {
 		_, remotePath = filepath.Split(cmd.localPath)
 	}
 
-	cmd.logger.Debug("Uploading %s to %s/%s", cmd.localPath, container, remotePath)
-	// os.Exit(2)
+	cmd.logger.Debug("Uploading %s to %s/%s\n", cmd.localPath, container, remotePath)
 
 	// open the local file to be uploaded
 	f, err := os.Open(cmd.localPath)
@@ -33,39 +33,54 @@ func (cmd *SimpleCommand) putBlob() error {
 	defer f.Close()
 
 	// get the client
-	client, err := cmd.config.getBlobStorageClient()
-	if err != nil {
-		return err
-	}
-
-	// create the blob, if it doesn't exists (Block Blob)
-	err = client.CreateBlockBlob(container, remotePath)
+	client, err := cmd.config.getBlobStorageClient(cmd.ctx)
 	if err != nil {
 		return err
 	}
 
 	buf := make([]byte, maxBlockSize, maxBlockSize)
-	var rdErr error = nil
 	var blocks []storage.Block
+	var uploaded int64
 
-	// upload the blob one block at a time
-	for i := 0; rdErr == nil && err == nil; i++ {
-		_, rdErr = f.Read(buf)
-		blockId := fmt.Sprintf("%10i", i)
-		err = client.PutBlock(container, remotePath, blockId, buf[:])
-		blocks = append(blocks, storage.Block{ID: blockId})
+	abort := func() error {
+		cmd.logger.Info("Interrupted after uploading %d bytes to %s/%s; nothing was committed\n",
+			uploaded, container, remotePath)
+		return ErrInterrupted
 	}
 
-	if rdErr != io.EOF {
-		return rdErr
-	}
-
-	if err != nil {
-		return err
+	// upload the blob one block at a time.  Nothing is visible until the
+	// block list is committed, so an interrupted upload leaves any existing
+	// blob untouched and its blocks are discarded by the service.
+	for i := 0; ; i++ {
+		n, rdErr := io.ReadFull(f, buf)
+		if n > 0 {
+			// block ids must be base64 and all the same length
+			blockId := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%010d", i)))
+			err = client.PutBlock(container, remotePath, blockId, buf[:n])
+			if err != nil {
+				if cmd.ctx.Err() != nil {
+					return abort()
+				}
+				return err
+			}
+
+			blocks = append(blocks, storage.Block{ID: blockId, Status: storage.BlockStatusUncommitted})
+			uploaded += int64(n)
+		}
+
+		if rdErr == io.EOF || rdErr == io.ErrUnexpectedEOF {
+			break
+		} else if rdErr != nil {
+			return rdErr
+		}
+
+		if cmd.ctx.Err() != nil {
+			return abort()
+		}
 	}
 
 	if err = client.PutBlockList(container, remotePath, blocks); err != nil {
-		return err
+		return interrupted(cmd.ctx, err)
 	}
 
 	return nil
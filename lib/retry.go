@@ -0,0 +1,181 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed call to the blob service is
+// repeated.  It is applied to every request a blob storage client makes, via
+// retryTransport.  It doesn't cover the accounts commands: the management
+// client builds its own HTTP client for each request, leaving nowhere to add
+// the transport, and retries by its own rules.
+type RetryPolicy struct {
+	MaxAttempts   int           // total tries per request, including the first
+	BaseDelay     time.Duration // wait before the first retry; doubles each time
+	MaxDelay      time.Duration // upper bound on any wait, even one the server asks for
+	Jitter        bool          // randomize waits so workers don't retry in lockstep
+	StatusCodes   []int         // HTTP statuses worth retrying
+	NetworkErrors bool          // retry timeouts, refused and reset connections
+	Logger        Logger        // retries are logged at Debug level, if set
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   4,
+		BaseDelay:     500 * time.Millisecond,
+		MaxDelay:      30 * time.Second,
+		Jitter:        true,
+		StatusCodes:   []int{408, 429, 500, 502, 503, 504},
+		NetworkErrors: true,
+	}
+}
+
+// Backoff returns how long to wait before the given retry (starting at 1).
+// With jitter, the wait is drawn from the upper half of the exponential delay.
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if p.Jitter && d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)))
+	}
+
+	return d
+}
+
+func (p *RetryPolicy) retryableStatus(code int) bool {
+	for _, c := range p.StatusCodes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *RetryPolicy) retryableError(err error) bool {
+	if !p.NetworkErrors {
+		return false
+	}
+
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return true
+	}
+
+	for _, e := range []error{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EPIPE, io.EOF, io.ErrUnexpectedEOF} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *RetryPolicy) debug(format string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Debug(format, args...)
+	}
+}
+
+// retryTransport repeats requests that fail in a way the policy considers
+// transient.  Working at this level lets it honor Retry-After, which the
+// storage client doesn't expose.
+type retryTransport struct {
+	ctx    context.Context // cancels requests and the waits between them
+	policy *RetryPolicy
+	next   http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.ctx != nil {
+		req = req.WithContext(t.ctx)
+	}
+
+	cur := req
+	for attempt := 1; ; attempt++ {
+		res, err := t.next.RoundTrip(cur)
+
+		var reason string
+		if err != nil {
+			if !t.policy.retryableError(err) {
+				return res, err
+			}
+			reason = err.Error()
+		} else if t.policy.retryableStatus(res.StatusCode) {
+			reason = res.Status
+		} else {
+			return res, err
+		}
+
+		// A request whose body can't be replayed can only be sent once
+		if attempt >= t.policy.MaxAttempts || (req.Body != nil && req.GetBody == nil) {
+			return res, err
+		}
+
+		wait := t.policy.Backoff(attempt)
+		if res != nil {
+			if after := retryAfter(res); after > wait {
+				wait = after
+			}
+			if t.policy.MaxDelay > 0 && wait > t.policy.MaxDelay {
+				wait = t.policy.MaxDelay
+			}
+
+			// Let the connection be reused
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		t.policy.debug("Retrying %s %s in %s (attempt %d of %d): %s\n",
+			req.Method, req.URL.Path, wait, attempt+1, t.policy.MaxAttempts, reason)
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		// RoundTrippers mustn't modify the caller's request
+		cur = req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			cur.Body = body
+		}
+	}
+}
+
+// retryAfter reads a Retry-After header, given either in seconds or as a date
+func retryAfter(res *http.Response) time.Duration {
+	h := res.Header.Get("Retry-After")
+	if h == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(h); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestRetryBackoff(c *C) {
+	p := DefaultRetryPolicy()
+	p.Jitter = false
+
+	c.Assert(p.Backoff(1), Equals, 500*time.Millisecond)
+	c.Assert(p.Backoff(2), Equals, time.Second)
+	c.Assert(p.Backoff(3), Equals, 2*time.Second)
+	c.Assert(p.Backoff(20), Equals, p.MaxDelay)
+
+	p.Jitter = true
+	for i := 0; i < 100; i++ {
+		d := p.Backoff(2)
+		c.Assert(d >= 500*time.Millisecond && d < time.Second, Equals, true)
+	}
+}
+
+func (s *S) TestRetryTransport(c *C) {
+	var bodies []string
+	failures := 2
+	after := "0"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+
+		if failures > 0 {
+			failures--
+			w.Header().Set("Retry-After", after)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p := DefaultRetryPolicy()
+	p.BaseDelay = time.Millisecond
+	client := &http.Client{Transport: &retryTransport{nil, &p, http.DefaultTransport}}
+
+	// Transient failures are retried, replaying the request body
+	res, err := client.Post(server.URL, "text/plain", bytes.NewReader([]byte("block")))
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(bodies, DeepEquals, []string{"block", "block", "block"})
+
+	// Other errors are returned immediately
+	bodies = nil
+	res, err = client.Get(server.URL + "/missing")
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+	c.Assert(len(bodies), Equals, 1)
+
+	// Attempts are capped
+	bodies = nil
+	failures = 10
+	p.MaxAttempts = 3
+	res, err = client.Get(server.URL)
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Assert(len(bodies), Equals, 3)
+
+	// The server's Retry-After is capped like the backoff
+	bodies = nil
+	failures = 1
+	after = "3600"
+	p.MaxDelay = 10 * time.Millisecond
+	start := time.Now()
+	res, err = client.Get(server.URL)
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(len(bodies), Equals, 2)
+	c.Assert(time.Since(start) < time.Minute, Equals, true)
+}
@@ -1,24 +1,60 @@
 package lib
 
+import (
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
 func (cmd *SimpleCommand) rmBlob() error {
 
 	// get the client
-	client, err := cmd.config.getBlobStorageClient()
+	client, err := cmd.config.getBlobStorageClient(cmd.ctx)
 	if err != nil {
 		return err
 	}
 
+	names := []string{cmd.source.Path}
+	if cmd.Recursive {
+		// remove every blob under the path, or matching the pattern
+		arr, err := cmd.listBlobsInternal(client)
+		if err != nil {
+			return err
+		}
+
+		names = names[:0]
+		for _, u := range arr {
+			names = append(names, u.Name)
+		}
+	}
+
 	if cmd.destructive == false {
-		cmd.logger.Info("Would remove %s\n", cmd.source.Path)
+		for _, name := range names {
+			cmd.logger.Info("Would remove %s\n", name)
+		}
 		return nil
 	}
 
 	// query the endpoint
-	extraHeaders := map[string]string{}
-	_, err = client.DeleteBlobIfExists(cmd.source.Container, cmd.source.Path, extraHeaders)
-	if err != nil {
-		return err
+	for i, name := range names {
+		if err := removeBlob(client, cmd.source.Container, name); err != nil {
+			if cmd.ctx.Err() != nil {
+				cmd.logger.Info("Interrupted after removing %d of %d blobs\n", i, len(names))
+			}
+			return interrupted(cmd.ctx, err)
+		}
+		cmd.logger.Debug("Removed %s\n", name)
 	}
 
 	return nil
 }
+
+// removeBlob deletes a blob, letting one that's already gone go.
+// (DeleteBlobIfExists panics when a request fails outright, as when
+// interrupted, so it can't be used.)
+func removeBlob(client *storage.BlobStorageClient, container, name string) error {
+	err := client.DeleteBlob(container, name, map[string]string{})
+	if sse, ok := err.(storage.AzureStorageServiceError); ok && sse.Code == "BlobNotFound" {
+		return nil
+	}
+
+	return err
+}
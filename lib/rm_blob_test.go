@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestRmRecursive(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"c": {
+			testBlob("logs-archive/old.log", 1),
+			testBlob("logs.txt", 1),
+			testBlob("logs/a.log", 1),
+			testBlob("logs/b.log", 1),
+			testBlob("logs/c.log", 1),
+		},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	run := func(ctx context.Context, spec string, force bool) (string, error) {
+		cmd := &SimpleCommand{Command: "rm", Recursive: true}
+		cmd.SetDestructive(force)
+		return runCommand(c, ctx, cfg, cmd, "", spec)
+	}
+
+	// Without -f, rm only says what it would remove
+	out, err := run(context.Background(), "c/logs", false)
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "Would remove logs/a.log\nWould remove logs/b.log\nWould remove logs/c.log\n")
+	c.Assert(svc.blobNames("c"), HasLen, 5)
+
+	// Interrupted, rm says how far it got
+	ctx, cancel := context.WithCancel(context.Background())
+	svc.failDelete = func(name string) bool {
+		if name == "logs/b.log" {
+			cancel()
+			return true
+		}
+		return false
+	}
+	out, err = run(ctx, "c/logs", true)
+	c.Assert(err, Equals, ErrInterrupted)
+	c.Assert(out, Equals, "Interrupted after removing 1 of 3 blobs\n")
+	c.Assert(svc.blobNames("c"), DeepEquals, []string{"logs-archive/old.log", "logs.txt", "logs/b.log", "logs/c.log"})
+
+	svc.failDelete = nil
+	out, err = run(context.Background(), "c/logs", true)
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "")
+	c.Assert(svc.blobNames("c"), DeepEquals, []string{"logs-archive/old.log", "logs.txt"})
+
+	out, err = run(context.Background(), "c/*.txt", true)
+	c.Assert(err, IsNil)
+	c.Assert(svc.blobNames("c"), DeepEquals, []string{"logs-archive/old.log"})
+}
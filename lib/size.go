@@ -1,20 +1,34 @@
 package lib
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/storage"
-	"github.com/alecthomas/units"
+)
+
+var (
+	ErrIncompleteResult = errors.New("some sources could not be counted")
 )
 
 type SizeCommand struct {
+	KeepGoing     bool   // report partial totals when a source fails, rather than stopping
+	GroupBy       string // also total by content-type, extension or month
+	Histogram     bool   // also count blobs into buckets by size
+	include       string // details to list beyond properties, such as metadata
+	whole         bool   // without KeepGoing, never report a partial result, even as JSON
+	groups        *sizeGroups
+	histogram     sizeHistogram
 	config        *AzbConfig
 	sources       []*BlobSpec
 	outputMode    string
 	workers       int
 	workerTimeout time.Duration
 	logger        Logger
+	ctx           context.Context
 }
 
 // Command interface
@@ -30,12 +44,135 @@ func (cmd *SizeCommand) SetWorkers(n int)          { cmd.workers = n }
 func (cmd *SizeCommand) SetLogger(l Logger)        { cmd.logger = l }
 func (cmd *SizeCommand) Logger() Logger            { return cmd.logger }
 
-func (cmd *SizeCommand) Dispatch() error {
+// sizeError records a source that could not be counted
+type sizeError struct {
+	Source string `json:"source"`
+	Error  string `json:"error"`
+	err    error
+}
+
+// sizeTotal is the size of the blobs matching a source, or all sources
+type sizeTotal struct {
+	Source string `json:"source,omitempty"`
+	Size   int64  `json:"size"`
+	Blobs  int    `json:"blobs"`
+}
+
+func (cmd *SizeCommand) Dispatch(ctx context.Context) error {
+	start := time.Now()
+
+	if cmd.GroupBy != "" {
+		groups, err := newSizeGroups(cmd.GroupBy)
+		if err != nil {
+			return err
+		}
+		cmd.groups = groups
+	}
+
+	if cmd.Histogram {
+		cmd.histogram = newSizeHistogram()
+	}
+
+	// Count up the size as we go, both overall and for each source
+	total := &sizeTotal{}
+	subtotals := []*sizeTotal{}
+	bySource := map[string]*sizeTotal{}
+	for _, src := range cmd.sources {
+		if _, ok := bySource[src.String()]; !ok {
+			t := &sizeTotal{Source: src.String()}
+			subtotals = append(subtotals, t)
+			bySource[t.Source] = t
+		}
+	}
+
+	failures, err := cmd.scan(ctx, func(batch *sizeBatch) {
+		sub := bySource[batch.origin]
+		for _, b := range batch.blobs {
+			sub.Size += b.ContentLength
+			total.Size += b.ContentLength
+
+			if cmd.groups != nil {
+				cmd.groups.add(b)
+			}
+			if cmd.histogram != nil {
+				cmd.histogram.add(b)
+			}
+		}
+		sub.Blobs += len(batch.blobs)
+		total.Blobs += len(batch.blobs)
+	})
+	if err != nil {
+		return err
+	}
+
+	return cmd.finishScan(ctx, total.Blobs, total.Size, failures, func() error {
+		cmd.sizeReport(total, subtotals, time.Since(start), failures)
+		return nil
+	})
+}
+
+// finishScan settles how a scan of blobs, size bytes in all, ends.  An
+// interrupted scan isn't reported.  Nor, without --keep-going, is one with
+// failures, since a partial total would be misleading; JSON lists the
+// failures alongside it, so is let through unless whole is set.  Otherwise
+// report runs, and any failures make for ErrIncompleteResult.
+func (cmd *SizeCommand) finishScan(ctx context.Context, blobs int, size int64, failures []*sizeError, report func() error) error {
+	if ctx.Err() != nil {
+		cmd.logger.Info("Interrupted after counting %d blobs (%d bytes)\n", blobs, size)
+		return ErrInterrupted
+	}
+
+	if len(failures) > 0 && !cmd.KeepGoing && (cmd.whole || cmd.outputMode != "json") {
+		return failures[0].err
+	}
+
+	if err := report(); err != nil {
+		return err
+	}
+
+	if len(failures) > 0 {
+		return ErrIncompleteResult
+	}
+
+	return nil
+}
+
+// sizeSource is a source to list, along with the source given on the
+// command line that it was expanded from
+type sizeSource struct {
+	*BlobSpec
+	origin string
+}
+
+// sizeBatch is every blob listed for one source
+type sizeBatch struct {
+	origin    string
+	container string
+	blobs     []*blob
+	listed    []storage.Blob // the same blobs as listed, with any details include asked for
+}
+
+// scan lists every blob matching the sources across the workers, handing
+// each source's blobs to collect as they arrive.  collect is only ever called
+// from the calling goroutine.  Sources that fail are returned; unless
+// KeepGoing is set, the first failure stops the scan.
+func (cmd *SizeCommand) scan(ctx context.Context, collect func(batch *sizeBatch)) ([]*sizeError, error) {
+	// Unless we're keeping going, the first failure stops the other workers
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd.ctx = runCtx
+
 	// default for now
 	cmd.workerTimeout = 100 * time.Millisecond
 
-	sourcesChan := make(chan *BlobSpec)
-	blobChan := make(chan []*blob)
+	client, err := cmd.config.getBlobStorageClient(runCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	sourcesChan := make(chan *sizeSource)
+	blobChan := make(chan *sizeBatch)
+	errChan := make(chan *sizeError)
 	// Keep track of sources that need to be expanded.
 	// Once we've expanded all necessary sources, we can close sourcesChan.
 	expandedChan := make(chan string)
@@ -48,28 +185,33 @@ func (cmd *SizeCommand) Dispatch() error {
 	numWorkers := cmd.workers + len(cmd.sources)
 	for i := 0; i < numWorkers; i++ {
 		id := fmt.Sprintf("%d", i)
-		go (*cmd).sizeWorker(id, sourcesChan, blobChan, expandedChan, exitChan)
+		go (*cmd).sizeWorker(id, client, sourcesChan, blobChan, errChan, expandedChan, exitChan)
 	}
 
-	// First, send all input sources to our workers
+	// First, send all input sources to our workers.  Count up how many
+	// do not have a path: we need to expand all such sources, and when we
+	// have done so, we can close the sources channel.
+	numToExpand := 0
+sendLoop:
 	for _, src := range cmd.sources {
 		cmd.logger.Debug("Sending source '%s'\n", src)
-		sourcesChan <- src
+		select {
+		case sourcesChan <- &sizeSource{src, src.String()}:
+			if !src.PathPresent {
+				numToExpand++
+			}
+		case <-runCtx.Done():
+			break sendLoop
+		}
 	}
 
 	cmd.logger.Debug("-------\nDone sending sources\n------\n\n")
 
-	// Count up how many sources do not have a path. We need to expand all
-	// such sources.  When we have done so, we can close the sources channel.
-	numToExpand := 0
-	for _, src := range cmd.sources {
-		if !src.PathPresent {
-			numToExpand++
-		}
+	if numToExpand == 0 {
+		close(sourcesChan)
 	}
 
-	// Count up the size as we go
-	var size int64 = 0
+	failures := []*sizeError{}
 	// Wait for our workers to list out blobs.
 waitLoop:
 	for {
@@ -88,46 +230,151 @@ waitLoop:
 				cmd.logger.Debug("------\nDone counting blobs\n------\n\n")
 				close(blobChan)
 			}
-		case blobs, ok := <-blobChan:
+		case e := <-errChan:
+			cmd.logger.Debug("Failed to count %s: %s\n", e.Source, e.Error)
+			failures = append(failures, e)
+			if !cmd.KeepGoing {
+				cancel()
+			}
+		case batch, ok := <-blobChan:
 			// Once blob chan is closed and empty, stop receiving
 			if !ok {
 				break waitLoop
 			}
 
-			for _, b := range blobs {
-				size += b.ContentLength
+			collect(batch)
+		}
+	}
+
+	return failures, nil
+}
+
+func (cmd *SizeCommand) sizeReport(total *sizeTotal, subtotals []*sizeTotal,
+	elapsed time.Duration, failures []*sizeError) {
+
+	if cmd.outputMode == "json" {
+		tmp := struct {
+			StorageAccount string        `json:"storageAccount"`
+			Size           int64         `json:"size"`
+			Blobs          int           `json:"blobs"`
+			Sources        []*sizeTotal  `json:"sources"`
+			GroupBy        string        `json:"groupBy,omitempty"`
+			Groups         []*sizeGroup  `json:"groups,omitempty"`
+			Histogram      sizeHistogram `json:"histogram,omitempty"`
+			ElapsedMs      int64         `json:"elapsedMs"`
+			Errors         []*sizeError  `json:"errors"`
+		}{
+			StorageAccount: cmd.config.Name,
+			Size:           total.Size,
+			Blobs:          total.Blobs,
+			Sources:        subtotals,
+			GroupBy:        cmd.GroupBy,
+			Histogram:      cmd.histogram,
+			ElapsedMs:      int64(elapsed / time.Millisecond),
+			Errors:         failures,
+		}
+		if cmd.groups != nil {
+			tmp.Groups = cmd.groups.sorted(total.Size)
+		}
+
+		s, _ := json.Marshal(tmp)
+		cmd.logger.Info("%s\n", s)
+		return
+	}
+
+	// A single source's subtotal is just the total
+	if len(subtotals) > 1 {
+		for _, t := range subtotals {
+			source := t.Source
+			if source == "" {
+				source = "(all containers)"
 			}
+			cmd.logger.Info("%9s %10d  %s\n", formatSize(t.Size), t.Blobs, source)
+		}
+	}
+
+	if cmd.groups != nil {
+		cmd.logger.Info("By %s:\n", cmd.GroupBy)
+		for _, g := range cmd.groups.sorted(total.Size) {
+			cmd.logger.Info("%9s %10d %6.1f%%  %s\n", formatSize(g.Size), g.Blobs, g.Percent, g.Group)
+		}
+	}
+
+	if cmd.histogram != nil {
+		cmd.logger.Info("By blob size:\n")
+		for _, b := range cmd.histogram {
+			cmd.logger.Info("%12s %10d %9s  %s\n", b.label(), b.Blobs, formatSize(b.Size), histogramBar(b.Blobs, total.Blobs))
+		}
+	}
+
+	cmd.logger.Info("Total size: %s (%d bytes) in %d blobs, took %s\n",
+		formatSize(total.Size), total.Size, total.Blobs, elapsed.Round(time.Millisecond))
+
+	reportFailures(cmd.logger, failures)
+}
+
+// reportFailures lists the sources a scan couldn't count
+func reportFailures(logger Logger, failures []*sizeError) {
+	if len(failures) > 0 {
+		logger.Info("Could not count %d sources:\n", len(failures))
+		for _, e := range failures {
+			logger.Info("  %s: %s\n", e.Source, e.Error)
 		}
 	}
+}
+
+// formatSize gives a byte count in decimal units, e.g. 1.50 MB
+func formatSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
 
-	unitMap := units.MakeUnitMap("B", "b", 1000)
-	for k, v := range unitMap {
-		szUnit := float64(size) / float64(v)
-		if szUnit < 1000 && szUnit > 1 {
-			cmd.logger.Info("Total size: %.2f %s\n", szUnit, k)
+	f := float64(n)
+	suffix := "B"
+	for _, u := range []string{"KB", "MB", "GB", "TB", "PB", "EB"} {
+		f /= unit
+		suffix = u
+		if f < unit {
 			break
 		}
 	}
 
-	return nil
+	return fmt.Sprintf("%.2f %s", f, suffix)
 }
 
-func (cmd SizeCommand) sizeWorker(id string, sources chan *BlobSpec,
-	blobs chan<- []*blob, expanded chan<- string, exited chan<- string) {
+func (cmd SizeCommand) sizeWorker(id string, client *storage.BlobStorageClient,
+	sources chan *sizeSource, blobs chan<- *sizeBatch, errs chan<- *sizeError,
+	expanded chan<- string, exited chan<- string) {
 
-	client, err := cmd.config.getBlobStorageClient()
-	if err != nil {
-		panic("Failed to get blob storage client in worker.")
+	defer func() { exited <- id }()
+
+	fail := func(src *sizeSource, err error) {
+		// Failures caused by stopping early aren't worth reporting
+		if cmd.ctx.Err() != nil {
+			return
+		}
+
+		err = handleListError(err)
+		errs <- &sizeError{Source: src.String(), Error: err.Error(), err: err}
 	}
 
 	for src := range sources {
+		// Once cancelled, drain the remaining sources without listing them
+		if cmd.ctx.Err() != nil {
+			if !src.PathPresent {
+				expanded <- id
+			}
+			continue
+		}
+
 		if !src.PathPresent {
 			// We need to break this source down into all containers it could refer to
 			// List all containers for this source, and enqueue them back onto sources
 			// Then get out
-			err := sendContainersToChannel(client, sources, src)
+			err := sendContainersToChannel(cmd.ctx, client, sources, src)
 			if err != nil {
-				panic("Failed to list containers for source without blob path")
+				fail(src, err)
 			}
 
 			expanded <- id
@@ -136,51 +383,69 @@ func (cmd SizeCommand) sizeWorker(id string, sources chan *BlobSpec,
 
 		// We have a path present, so we can list all matching blobs and count their
 		// size.
-		params := storage.ListBlobsParameters{Prefix: src.Path, MaxResults: 5000}
-
-		var curBlobs []*blob
-		res := storage.BlobListResponse{}
-		for firstTime := true; firstTime || res.NextMarker != ""; firstTime = false {
-
-			var err error
-			for i := 0; i < 3; i++ {
-				res, err = client.ListBlobs(src.Container, params)
-				if err == nil {
-					break
-				}
-			}
-
-			if err != nil {
-				panic("Failed to list blobs in worker after 3 retries.  Aborting")
-			}
-
-			// flatten results
-			for _, u := range res.Blobs {
-				curBlobs = append(curBlobs, newBlob(u))
+		batch := &sizeBatch{origin: src.origin, container: src.Container}
+		err := eachListedBlob(client, src.BlobSpec, cmd.include, func(u storage.Blob, b *blob) {
+			batch.blobs = append(batch.blobs, b)
+			if cmd.include != "" {
+				batch.listed = append(batch.listed, u)
 			}
-
-			params.Marker = res.NextMarker
+		})
+		if err != nil {
+			fail(src, err)
+			continue
 		}
 
 		cmd.logger.Debug("Worker %s finished enumerating container %s\n", id, src.Container)
-		blobs <- curBlobs
+		blobs <- batch
+	}
+}
+
+// listAllBlobs follows continuation markers until every blob matching src is
+// listed, including any pattern.  Nothing is returned on failure, so a source
+// is counted whole or not at all.
+func listAllBlobs(client *storage.BlobStorageClient, src *BlobSpec) ([]*blob, error) {
+	var curBlobs []*blob
+	err := eachListedBlob(client, src, "", func(u storage.Blob, b *blob) {
+		curBlobs = append(curBlobs, b)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	exited <- id
+	return curBlobs, nil
 }
 
-func sendContainersToChannel(client *storage.BlobStorageClient,
-	outChan chan<- *BlobSpec, src *BlobSpec) error {
+// eachListedBlob hands fn every blob matching src, both as listed and as a
+// blob, listing any details include asks for, such as metadata
+func eachListedBlob(client *storage.BlobStorageClient, src *BlobSpec, include string, fn func(u storage.Blob, b *blob)) error {
+	params := storage.ListBlobsParameters{Prefix: src.Path, Include: include, MaxResults: 5000}
 
-	var err error
-	var containers []*container
-	for i := 0; i < 3; i++ {
-		containers, err = listContainersInternal(client, src.Container)
-		if err == nil {
-			break
+	res := storage.BlobListResponse{}
+	for firstTime := true; firstTime || res.NextMarker != ""; firstTime = false {
+
+		var err error
+		res, err = client.ListBlobs(src.Container, params)
+		if err != nil {
+			return err
+		}
+
+		// flatten results
+		for _, u := range res.Blobs {
+			if b := newBlob(u); src.Selects(b) {
+				fn(u, b)
+			}
 		}
+
+		params.Marker = res.NextMarker
 	}
 
+	return nil
+}
+
+func sendContainersToChannel(ctx context.Context, client *storage.BlobStorageClient,
+	outChan chan<- *sizeSource, src *sizeSource) error {
+
+	containers, err := listContainersInternal(ctx, client, src.Container)
 	if err != nil {
 		return err
 	}
@@ -190,8 +455,13 @@ func sendContainersToChannel(client *storage.BlobStorageClient,
 			Container:   c.Name,
 			Path:        "",
 			PathPresent: true,
+			Filter:      src.Filter,
+		}
+		select {
+		case outChan <- &sizeSource{&bs, src.origin}:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		outChan <- &bs
 	}
 
 	return nil
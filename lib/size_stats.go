@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrUnknownGrouping = errors.New("unknown grouping; expected content-type, extension or month")
+)
+
+// sizeGroupKey returns the group a blob falls into for size --group-by
+func sizeGroupKey(groupBy string, b *blob) (string, error) {
+	switch groupBy {
+	case "content-type":
+		if b.ContentType == "" {
+			return "(none)", nil
+		}
+		return b.ContentType, nil
+	case "extension":
+		ext := strings.ToLower(path.Ext(b.Name))
+		if ext == "" {
+			return "(none)", nil
+		}
+		return ext, nil
+	case "month":
+		if b.LastModified.IsZero() {
+			return "(unknown)", nil
+		}
+		return b.LastModified.Format("2006-01"), nil
+	default:
+		return "", ErrUnknownGrouping
+	}
+}
+
+type sizeGroup struct {
+	Group   string  `json:"group"`
+	Size    int64   `json:"size"`
+	Blobs   int     `json:"blobs"`
+	Percent float64 `json:"percent"`
+}
+
+// sizeGroups totals blobs by one of their properties
+type sizeGroups struct {
+	groupBy string
+	groups  map[string]*sizeGroup
+}
+
+func newSizeGroups(groupBy string) (*sizeGroups, error) {
+	if _, err := sizeGroupKey(groupBy, &blob{}); err != nil {
+		return nil, err
+	}
+
+	return &sizeGroups{groupBy, map[string]*sizeGroup{}}, nil
+}
+
+func (g *sizeGroups) add(b *blob) {
+	key, _ := sizeGroupKey(g.groupBy, b)
+
+	grp, ok := g.groups[key]
+	if !ok {
+		grp = &sizeGroup{Group: key}
+		g.groups[key] = grp
+	}
+
+	grp.Size += b.ContentLength
+	grp.Blobs++
+}
+
+// sorted lists the groups largest first, except months, which are listed
+// oldest first
+func (g *sizeGroups) sorted(total int64) []*sizeGroup {
+	arr := []*sizeGroup{}
+	for _, grp := range g.groups {
+		if total > 0 {
+			grp.Percent = float64(grp.Size) * 100 / float64(total)
+		}
+		arr = append(arr, grp)
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		if g.groupBy != "month" && arr[i].Size != arr[j].Size {
+			return arr[i].Size > arr[j].Size
+		}
+		return arr[i].Group < arr[j].Group
+	})
+
+	return arr
+}
+
+// histogramBounds are the upper bounds of the histogram buckets.  The last
+// bucket is unbounded.
+var histogramBounds = []int64{1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10}
+
+type sizeBucket struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max,omitempty"` // exclusive; absent for the last bucket
+	Size  int64 `json:"size"`
+	Blobs int   `json:"blobs"`
+}
+
+func (b *sizeBucket) label() string {
+	if b.Max == 0 {
+		return ">= " + formatSize(b.Min)
+	}
+	return "< " + formatSize(b.Max)
+}
+
+// sizeHistogram counts blobs into buckets by size
+type sizeHistogram []*sizeBucket
+
+func newSizeHistogram() sizeHistogram {
+	h := sizeHistogram{}
+	var min int64
+	for _, max := range histogramBounds {
+		h = append(h, &sizeBucket{Min: min, Max: max})
+		min = max
+	}
+
+	return append(h, &sizeBucket{Min: min})
+}
+
+func (h sizeHistogram) add(b *blob) {
+	for _, bucket := range h {
+		if bucket.Max == 0 || b.ContentLength < bucket.Max {
+			bucket.Size += b.ContentLength
+			bucket.Blobs++
+			return
+		}
+	}
+}
+
+// histogramBar draws a bucket's share of the blobs, up to 40 characters wide
+func histogramBar(n, total int) string {
+	if total == 0 {
+		return ""
+	}
+
+	return strings.Repeat("#", (n*40+total-1)/total)
+}
@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestSizeGroups(c *C) {
+	may := time.Date(2016, 5, 3, 0, 0, 0, 0, time.UTC)
+	blobs := []*blob{
+		{Name: "img/a.PNG", ContentType: "image/png", ContentLength: 600, LastModified: may},
+		{Name: "img/b.png", ContentType: "image/png", ContentLength: 200, LastModified: may.AddDate(-1, 0, 0)},
+		{Name: "README", ContentLength: 200},
+	}
+
+	groupsOf := func(groupBy string) []*sizeGroup {
+		g, err := newSizeGroups(groupBy)
+		c.Assert(err, IsNil)
+		for _, b := range blobs {
+			g.add(b)
+		}
+		return g.sorted(1000)
+	}
+
+	c.Assert(groupsOf("extension"), DeepEquals, []*sizeGroup{
+		{".png", 800, 2, 80},
+		{"(none)", 200, 1, 20},
+	})
+	c.Assert(groupsOf("content-type")[0], DeepEquals, &sizeGroup{"image/png", 800, 2, 80})
+
+	// Months read oldest first
+	c.Assert(groupsOf("month"), DeepEquals, []*sizeGroup{
+		{"(unknown)", 200, 1, 20},
+		{"2015-05", 200, 1, 20},
+		{"2016-05", 600, 1, 60},
+	})
+
+	_, err := newSizeGroups("colour")
+	c.Assert(err, Equals, ErrUnknownGrouping)
+
+	// The storage client doesn't report access tiers to group by
+	_, err = newSizeGroups("tier")
+	c.Assert(err, Equals, ErrUnknownGrouping)
+}
+
+func (s *S) TestSizeHistogram(c *C) {
+	h := newSizeHistogram()
+	for _, n := range []int64{0, 999, 1000, 5e10} {
+		h.add(&blob{ContentLength: n})
+	}
+
+	c.Assert(h[0].Blobs, Equals, 2)
+	c.Assert(h[0].label(), Equals, "< 1.00 KB")
+	c.Assert(h[1].Blobs, Equals, 1)
+	c.Assert(h[len(h)-1], DeepEquals, &sizeBucket{Min: 1e10, Size: 5e10, Blobs: 1})
+	c.Assert(h[len(h)-1].label(), Equals, ">= 10.00 GB")
+	c.Assert(histogramBar(1, 4), Equals, "##########")
+}
@@ -0,0 +1,293 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+// A stand-in for the blob service, serving container and blob listings.
+// Blobs must be given in order.
+// Containers named "broken" refuse access.
+type blobService struct {
+	*httptest.Server
+	containers map[string][]storage.Blob
+	pageSize   int                    // if set, listings come back in pages of this many entries
+	contents   map[string]string      // the text of blobs, by container/name
+	ranges     []string               // the Range of each blob read
+	failDelete func(name string) bool // if set, refuses deletes it returns true for
+	mu         sync.Mutex
+}
+
+func newBlobService(containers map[string][]storage.Blob) *blobService {
+	svc := &blobService{containers: containers}
+	svc.Server = httptest.NewServer(http.HandlerFunc(svc.serve))
+	return svc
+}
+
+func (svc *blobService) serve(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	container := strings.Trim(r.URL.Path, "/")
+
+	switch {
+	case container == "" && q.Get("comp") == "list":
+		res := storage.ContainerListResponse{}
+		names := []string{}
+		for _, name := range svc.containerNames() {
+			if strings.HasPrefix(name, q.Get("prefix")) {
+				names = append(names, name)
+			}
+		}
+		start, end, next := svc.page(q, len(names))
+		for _, name := range names[start:end] {
+			res.Containers = append(res.Containers, storage.Container{Name: name})
+		}
+		res.NextMarker = next
+		xml.NewEncoder(w).Encode(res)
+	case r.Method == "DELETE" && strings.Contains(container, "/"):
+		z := strings.SplitN(container, "/", 2)
+		if svc.failDelete != nil && svc.failDelete(z[1]) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`<Error><Code>InternalError</Code><Message>no</Message></Error>`))
+			return
+		}
+
+		svc.mu.Lock()
+		defer svc.mu.Unlock()
+		blobs := svc.containers[z[0]]
+		for i, b := range blobs {
+			if b.Name == z[1] {
+				svc.containers[z[0]] = append(blobs[:i:i], blobs[i+1:]...)
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<Error><Code>BlobNotFound</Code><Message>no</Message></Error>`))
+	case r.Method == "GET" && strings.Contains(container, "/"):
+		text, ok := svc.contents[container]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>BlobNotFound</Code><Message>no</Message></Error>`))
+			return
+		}
+		svc.mu.Lock()
+		svc.ranges = append(svc.ranges, r.Header.Get("Range"))
+		svc.mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(text))
+	case container == "broken":
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<Error><Code>AuthorizationFailure</Code><Message>no</Message></Error>`))
+	case q.Get("comp") == "list":
+		svc.mu.Lock()
+		blobs, ok := svc.containers[container]
+		svc.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>ContainerNotFound</Code><Message>no</Message></Error>`))
+			return
+		}
+
+		prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+
+		// Each entry is a blob, or a prefix standing in for many
+		entries := []interface{}{}
+		for _, b := range blobs {
+			if !strings.HasPrefix(b.Name, prefix) {
+				continue
+			}
+
+			// Roll up blobs beyond the delimiter into prefixes
+			rest := strings.TrimPrefix(b.Name, prefix)
+			if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+				p := prefix + rest[:i+len(delimiter)]
+				if n := len(entries); n == 0 || entries[n-1] != p {
+					entries = append(entries, p)
+				}
+				continue
+			}
+
+			entries = append(entries, b)
+		}
+
+		res := storage.BlobListResponse{}
+		start, end, next := svc.page(q, len(entries))
+		for _, e := range entries[start:end] {
+			switch e := e.(type) {
+			case string:
+				res.BlobPrefixes = append(res.BlobPrefixes, e)
+			case storage.Blob:
+				res.Blobs = append(res.Blobs, e)
+			}
+		}
+		res.NextMarker = next
+		xml.NewEncoder(w).Encode(res)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// page picks the entries a listing request asks for, by marker and
+// maxresults, and the marker of the next page if there is one
+func (svc *blobService) page(q url.Values, n int) (start, end int, next string) {
+	start, _ = strconv.Atoi(q.Get("marker"))
+	if start > n {
+		start = n
+	}
+
+	limit := svc.pageSize
+	if m, _ := strconv.Atoi(q.Get("maxresults")); m > 0 && (limit == 0 || m < limit) {
+		limit = m
+	}
+
+	end = n
+	if limit > 0 && start+limit < n {
+		end = start + limit
+		next = strconv.Itoa(end)
+	}
+
+	return start, end, next
+}
+
+// readRanges returns the Range of each blob read so far, and forgets them
+func (svc *blobService) readRanges() []string {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	ranges := svc.ranges
+	svc.ranges = nil
+	return ranges
+}
+
+// blobNames lists the names of the blobs left in a container
+func (svc *blobService) blobNames(container string) []string {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	names := []string{}
+	for _, b := range svc.containers[container] {
+		names = append(names, b.Name)
+	}
+	return names
+}
+
+func (svc *blobService) containerNames() []string {
+	names := []string{}
+	for name := range svc.containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RoundTrip sends storage requests to the stand-in, whatever account they
+// were addressed to
+func (svc *blobService) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, _ := url.Parse(svc.URL)
+	req = req.Clone(req.Context())
+	req.URL.Scheme = u.Scheme
+	req.URL.Host = u.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// config returns an environment pointed at the stand-in.  Call the returned
+// function to restore the real transport.
+func (svc *blobService) config() (*AzbConfig, func()) {
+	storageTransport = svc
+	cfg := &AzbConfig{Name: "acct", AccessKey: "a2V5", BlobEndpoint: "core.windows.net", Retry: DefaultRetryPolicy()}
+	cfg.Retry.MaxAttempts = 1
+	return cfg, func() { storageTransport = http.DefaultTransport }
+}
+
+func testBlob(name string, size int64) storage.Blob {
+	return storage.Blob{Name: name, Properties: storage.BlobProperties{ContentLength: size}}
+}
+
+// runCommand dispatches cmd against the given blobspecs, printing as mode
+// with two workers, and returns what it logged
+func runCommand(c *C, ctx context.Context, cfg *AzbConfig, cmd Command, mode string, specs ...string) (string, error) {
+	lg := &bufferLogger{}
+	cmd.SetConfig(cfg)
+	cmd.SetLogger(lg)
+	cmd.SetOutputMode(mode)
+	cmd.SetWorkers(2)
+	for _, spec := range specs {
+		src, err := ParseBlobSpec(spec)
+		c.Assert(err, IsNil)
+		cmd.AddSource(src)
+	}
+
+	err := cmd.Dispatch(ctx)
+	return lg.String(), err
+}
+
+// runSimple runs a command that must succeed against one blobspec
+func runSimple(c *C, cfg *AzbConfig, cmd *SimpleCommand, spec, mode string) string {
+	out, err := runCommand(c, context.Background(), cfg, cmd, mode, spec)
+	c.Assert(err, IsNil)
+	return out
+}
+
+func (s *S) TestSizeErrors(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs":   {testBlob("a.log", 1500), testBlob("b.log", 500)},
+		"broken": nil,
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	run := func(keepGoing bool, mode string, specs ...string) (string, error) {
+		lg := &bufferLogger{}
+		cmd := &SizeCommand{KeepGoing: keepGoing}
+		cmd.SetConfig(cfg)
+		cmd.SetLogger(lg)
+		cmd.SetOutputMode(mode)
+		cmd.SetWorkers(2)
+		for _, spec := range specs {
+			bs, _ := ParseBlobSpec(spec)
+			cmd.AddSource(bs)
+		}
+		err := cmd.Dispatch(context.Background())
+		return lg.String(), err
+	}
+
+	out, err := run(false, "bare", "logs/")
+	c.Assert(err, IsNil)
+	c.Assert(out, Matches, "Total size: 2.00 KB \\(2000 bytes\\) in 2 blobs, took .*\n")
+
+	// Failures are returned rather than crashing the run
+	_, err = run(false, "bare", "logs/", "missing/")
+	c.Assert(err, Equals, ErrContainerNotFound)
+
+	// Keeping going reports what could be counted, and what couldn't
+	out, err = run(true, "bare", "logs/", "missing/", "broken/")
+	c.Assert(err, Equals, ErrIncompleteResult)
+	c.Assert(out, Matches, "(?s)  2.00 KB +2  logs/\n +0 B +0  missing/\n.*Total size: 2.00 KB.*\n"+
+		"Could not count 2 sources:\n.*missing/: container not found\n.*")
+
+	// Subtotals are kept for each source given, however it's expanded
+	out, err = run(true, "json", "", "missing/")
+	c.Assert(err, Equals, ErrIncompleteResult)
+
+	var report struct {
+		Size    int64
+		Blobs   int
+		Sources []*sizeTotal
+		Errors  []*sizeError
+	}
+	c.Assert(json.Unmarshal([]byte(out), &report), IsNil)
+	c.Assert(report.Size, Equals, int64(2000))
+	c.Assert(report.Blobs, Equals, 2)
+	c.Assert(report.Sources, DeepEquals, []*sizeTotal{{"", 2000, 2}, {"missing/", 0, 0}})
+	c.Assert(len(report.Errors), Equals, 2)
+}
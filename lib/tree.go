@@ -2,18 +2,23 @@ package lib
 
 import (
 	"encoding/json"
-	"os"
 	"sort"
 	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
 )
 
 type node struct {
-	Name  string
-	Nodes map[string]*node
+	Name     string
+	Nodes    map[string]*node
+	Size     int64     // a directory's is the total of the blobs under it
+	Blobs    int       `json:",omitempty"` // for a directory, the blobs under it
+	modified time.Time // a directory's is that of its newest blob
 }
 
 func dirNode(name string) *node {
-	return &node{name, map[string]*node{}}
+	return &node{Name: name, Nodes: map[string]*node{}}
 }
 
 func (n *node) Len() int {
@@ -21,11 +26,74 @@ func (n *node) Len() int {
 }
 
 func (n *node) AddFile(name string) *node {
-	r := &node{name, nil}
+	r := &node{Name: name}
 	n.Nodes[r.Name] = r
 	return r
 }
 
+// add counts a blob towards a directory's size and time
+func (n *node) add(u *blob) {
+	n.Size += u.ContentLength
+	if n.Nodes != nil {
+		n.Blobs++
+	}
+	if u.LastModified.After(n.modified) {
+		n.modified = u.LastModified
+	}
+}
+
+// children lists a directory's nodes by name, size (largest first) or time
+// (newest first), optionally with directories ahead of blobs.  Go maps
+// have no order, so printing must go through here to be repeatable.
+func (n *node) children(by string, dirsFirst, reverse bool) ([]*node, error) {
+	var less func(a, b *node) bool
+	switch by {
+	case "", "name":
+		less = func(a, b *node) bool { return false }
+	case "size":
+		less = func(a, b *node) bool { return a.Size > b.Size }
+	case "time":
+		less = func(a, b *node) bool { return a.modified.After(b.modified) }
+	default:
+		return nil, ErrUnknownSortKey
+	}
+
+	arr := []*node{}
+	for _, v := range n.Nodes {
+		arr = append(arr, v)
+	}
+
+	// Names break ties, so the order never depends on the map's
+	sort.Slice(arr, func(i, j int) bool {
+		a, b := arr[i], arr[j]
+		if dirsFirst && (a.Nodes == nil) != (b.Nodes == nil) {
+			return a.Nodes != nil
+		}
+		if reverse {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		} else if less(b, a) {
+			return false
+		}
+		return a.Name < b.Name
+	})
+
+	return arr, nil
+}
+
+// pruneBlobs leaves only directories, which still count the blobs under them
+func (n *node) pruneBlobs() {
+	for name, v := range n.Nodes {
+		if v.Nodes == nil {
+			delete(n.Nodes, name)
+		} else {
+			v.pruneBlobs()
+		}
+	}
+}
+
 type blobs []*blob
 
 // Ensure it satisfies sort.Interface
@@ -34,177 +102,182 @@ func (d blobs) Less(i, j int) bool { return d[i].Name < d[j].Name }
 func (d blobs) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
 
 func (cmd *SimpleCommand) treeBlobs() error {
+	printer, err := cmd.newTreePrinter()
+	if err != nil {
+		return err
+	}
+
 	// get the client
-	client, err := cmd.config.getBlobStorageClient()
+	client, err := cmd.config.getBlobStorageClient(cmd.ctx)
 	if err != nil {
 		return err
 	}
 
+	// Without a path, the source names a container or, as for ls, is a
+	// prefix of containers.  Then the tree starts from the account, with
+	// a directory for each container.
+	var containers []*container
+	if !cmd.source.PathPresent {
+		containers, err = listContainersInternal(cmd.ctx, client, cmd.source.Container)
+		if err != nil {
+			return err
+		}
+		// An empty account is an empty tree, but a name has to match
+		if len(containers) == 0 && cmd.source.Container != "" {
+			return ErrContainerNotFound
+		}
+		for _, c := range containers {
+			if c.Name == cmd.source.Container {
+				containers = nil
+				break
+			}
+		}
+	}
+
 	// query the endpoint
-	res, err := cmd.listBlobsInternal(client)
+	var arr blobs
+	if containers != nil {
+		arr, err = cmd.listAccountBlobs(client, containers)
+	} else {
+		arr, err = cmd.listBlobsInternal(client)
+	}
 	if err != nil {
 		return err
 	}
 
-	arr := blobs(res)
-
 	// sort results lexicographically
 	sort.Sort(arr)
 
-	root := buildTree(arr)
-
-	cmd.treeBlobsReport(root)
-
-	return nil
-}
-
-func buildTree(arr blobs) (root *node) {
-	root = dirNode(".")
-
-	for _, u := range arr {
-		dirs := strings.Split(u.Name, "/")
-
-		curr := root
-		for i := 0; i < len(dirs)-1; i++ {
-			name := dirs[i]
-
-			next, ok := curr.Nodes[name]
-			if !ok {
-				next = dirNode(name)
-				curr.Nodes[name] = next
+	// a --format lists the blobs themselves, in tree order
+	if cmd.records != nil {
+		for _, u := range arr {
+			if err := cmd.records.write(u); err != nil {
+				return err
 			}
-
-			curr = next
 		}
+		return nil
+	}
 
-		curr.AddFile(dirs[len(dirs)-1])
+	// a path roots the tree at its directory
+	prefix := ""
+	if i := strings.LastIndex(cmd.source.Path, "/"); i != -1 {
+		prefix = cmd.source.Path[:i+1]
 	}
 
-	return
-}
+	root := buildTree(arr, prefix, cmd.Depth)
+	if prefix != "" {
+		root.Name = strings.TrimSuffix(prefix, "/")
+	}
+	for _, c := range containers {
+		if _, ok := root.Nodes[c.Name]; !ok {
+			root.Nodes[c.Name] = dirNode(c.Name)
+		}
+	}
+	if cmd.DirsOnly {
+		root.pruneBlobs()
+	}
 
-// .
-// ├── Goopfile
-// ├── Goopfile.lock
-// ├── Makefile
-// ├── README.md
-// ├── TODO
-// ├── VERSION
-// ├── src
-// │   ├── azb.go
-// │   ├── blobspec.go
-// │   ├── blobspec_test.go
-// │   ├── cmd
-// │   │   └── azb
-// │   │       └── main.go
-// │   ├── config.go
-// │   ├── ls_blob.go
-// │   ├── ls_container.go
-// │   └── pull.go
-// └── tmp
-//     └── azb
-
-// .
-// B Goopfile
-// B Goopfile.lock
-// B Makefile
-// B README.md
-// B TODO
-// B VERSION
-// B src
-// T B azb.go
-// T B blobspec.go
-// T B blobspec_test.go
-// T B cmd
-// T T L azb
-// T T A L main.go
-// T B config.go
-// T B ls_blob.go
-// T B ls_container.go
-// T L pull.go
-// L tmp
-// A L azb
-
-const (
-	TRUNK  string = "│  "
-	BRANCH string = "├──"
-	LEAF   string = "└──"
-	AIR    string = "   "
-	STAR   string = "─x─"
-)
+	cmd.treeBlobsReport(root, printer)
 
-func (cmd *SimpleCommand) printRoot(node *node) (nd, nf int) {
-	return cmd.printTree(node, &Stack{})
+	return nil
 }
 
-func (cmd *SimpleCommand) printTree(node *node, stack *Stack) (nd, nf int) {
-	//	fmt.Printf("stack=[%s] len=%d\n", stack.String(), stack.Len())
+// listAccountBlobs lists the blobs of each container, up to cmd.workers
+// containers at a time.  The blobs are named container/name, so the
+// containers make the first level of the tree.
+func (cmd *SimpleCommand) listAccountBlobs(client *storage.BlobStorageClient, containers []*container) (blobs, error) {
+	type listing struct {
+		container string
+		blobs     []*blob
+		err       error
+	}
 
-	if stack.Len() > 0 {
-		cmd.logger.Info("%s ", strings.Join(stack.Reverse(), " "))
+	names := make(chan string)
+	done := make(chan *listing)
+
+	workers := cmd.workers
+	if workers > len(containers) {
+		workers = len(containers)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for name := range names {
+				src := &BlobSpec{Container: name, Filter: cmd.source.Filter}
+				arr, err := listAllBlobs(client, src)
+				done <- &listing{name, arr, err}
+			}
+		}()
 	}
 
-	cmd.logger.Info("%s\n", node.Name)
+	go func() {
+		for _, c := range containers {
+			names <- c.Name
+		}
+		close(names)
+	}()
+
+	// Wait for every container, so no worker is left blocked on done
+	arr := blobs{}
+	var first error
+	for range containers {
+		l := <-done
+		if l.err != nil {
+			if first == nil {
+				first = handleListError(interrupted(cmd.ctx, l.err))
+			}
+			continue
+		}
 
-	if node.Nodes == nil {
-		return 0, 1
+		for _, u := range l.blobs {
+			b := *u
+			b.Name = l.container + "/" + u.Name
+			arr = append(arr, &b)
+		}
 	}
 
-	top, _ := stack.Pop()
-	base := stack.Len()
+	return arr, first
+}
 
-	nd = 0
-	nf = 0
-	zz := node.Len() - 1
-	z0 := 0
+// buildTree arranges blobs by the directories of their names, after
+// prefix.  Given a depth, deeper directories are collapsed into the last
+// level shown, which still counts their blobs.
+func buildTree(arr blobs, prefix string, depth int) (root *node) {
+	root = dirNode(".")
 
-	for _, v := range node.Nodes {
+	for _, u := range arr {
+		dirs := strings.Split(strings.TrimPrefix(u.Name, prefix), "/")
 
-		switch top {
-		case "":
-			if z0 < zz {
-				stack.Push(BRANCH)
-			} else {
-				stack.Push(LEAF)
-			}
-			break
-		case BRANCH:
-			if z0 < zz {
-				stack.Push(TRUNK, BRANCH)
-			} else {
-				stack.Push(TRUNK, LEAF)
-			}
-			break
-		case LEAF:
-			if z0 < zz {
-				stack.Push(AIR, BRANCH)
-			} else {
-				stack.Push(AIR, LEAF)
+		curr := root
+		curr.add(u)
+		for i := 0; i < len(dirs)-1; i++ {
+			if depth > 0 && i >= depth {
+				break
 			}
-			break
-		default:
-			cmd.logger.Info("\n---")
-			cmd.logger.Info("invalid stack: ")
-			cmd.logger.Info("stack=%v, top=%s\n", base, top)
-			os.Exit(9)
-		}
 
-		xd, xf := cmd.printTree(v, stack)
+			name := dirs[i]
 
-		for stack.Len() > base {
-			stack.Pop()
-		}
+			next, ok := curr.Nodes[name]
+			if !ok {
+				next = dirNode(name)
+				curr.Nodes[name] = next
+			}
 
-		nd = nd + xd
-		nf = nf + xf
+			curr = next
+			curr.add(u)
+		}
 
-		z0++
+		if depth == 0 || len(dirs) <= depth {
+			curr.AddFile(dirs[len(dirs)-1]).add(u)
+		}
 	}
 
-	return nd + 1, nf
+	return
 }
 
-func (cmd *SimpleCommand) treeBlobsReport(root *node) {
+func (cmd *SimpleCommand) treeBlobsReport(root *node, printer *treePrinter) {
 	if cmd.outputMode == "json" {
 		tmp := struct {
 			StorageAccount string `json:"storageAccount"`
@@ -219,7 +292,7 @@ func (cmd *SimpleCommand) treeBlobsReport(root *node) {
 		s, _ := json.Marshal(tmp)
 		cmd.logger.Info("%s\n", s)
 	} else {
-		nd, nf := cmd.printRoot(root)
+		nd, nf := printer.printRoot(root)
 
 		cmd.logger.Debug("\n%d directories, %d files\n", nd, nf)
 	}
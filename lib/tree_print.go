@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrUnknownCharset = errors.New("unknown charset; expected ascii or unicode")
+)
+
+// .
+// ├── Goopfile
+// ├── Goopfile.lock
+// ├── Makefile
+// ├── README.md
+// ├── TODO
+// ├── VERSION
+// ├── src
+// │   ├── azb.go
+// │   ├── blobspec.go
+// │   ├── blobspec_test.go
+// │   ├── cmd
+// │   │   └── azb
+// │   │       └── main.go
+// │   ├── config.go
+// │   ├── ls_blob.go
+// │   ├── ls_container.go
+// │   └── pull.go
+// └── tmp
+//     └── azb
+
+// .
+// B Goopfile
+// B Goopfile.lock
+// B Makefile
+// B README.md
+// B TODO
+// B VERSION
+// B src
+// T B azb.go
+// T B blobspec.go
+// T B blobspec_test.go
+// T B cmd
+// T T L azb
+// T T A L main.go
+// T B config.go
+// T B ls_blob.go
+// T B ls_container.go
+// T L pull.go
+// L tmp
+// A L azb
+
+const (
+	TRUNK  string = "│  "
+	BRANCH string = "├──"
+	LEAF   string = "└──"
+	AIR    string = "   "
+	STAR   string = "─x─"
+)
+
+// treeCharset is the set of lines a tree is drawn with
+type treeCharset struct {
+	trunk, branch, leaf, air string
+}
+
+var treeCharsets = map[string]*treeCharset{
+	"unicode": {TRUNK, BRANCH, LEAF, AIR},
+	"ascii":   {"|  ", "|--", "`--", "   "},
+}
+
+// ANSI colors for names: directories, and blobs of at least largeBlobSize
+const (
+	colorDir   = "\x1b[1;34m"
+	colorLarge = "\x1b[1;31m"
+	colorReset = "\x1b[0m"
+
+	largeBlobSize = 1000 * 1000 * 1000
+)
+
+// treePrinter draws a tree of nodes a line at a time.  The stack holds the
+// lines to the left of each name, from the root down.
+type treePrinter struct {
+	logger     Logger
+	chars      *treeCharset
+	color      bool   // color names by kind
+	sortBy     string // name, size or time
+	dirsFirst  bool
+	reverse    bool
+	blobSizes  bool // show each blob's size
+	diskUsage  bool // show each directory's size and number of blobs
+	humanSizes bool
+}
+
+func (cmd *SimpleCommand) newTreePrinter() (*treePrinter, error) {
+	charset := cmd.Charset
+	if charset == "" {
+		charset = "unicode"
+	}
+	chars, ok := treeCharsets[charset]
+	if !ok {
+		return nil, ErrUnknownCharset
+	}
+
+	if _, err := dirNode(".").children(cmd.SortBy, cmd.DirsFirst, cmd.Reverse); err != nil {
+		return nil, err
+	}
+
+	return &treePrinter{
+		logger:     cmd.logger,
+		chars:      chars,
+		color:      cmd.Color,
+		sortBy:     cmd.SortBy,
+		dirsFirst:  cmd.DirsFirst,
+		reverse:    cmd.Reverse,
+		blobSizes:  cmd.BlobSizes,
+		diskUsage:  cmd.DiskUsage,
+		humanSizes: cmd.HumanSizes,
+	}, nil
+}
+
+func (p *treePrinter) printRoot(node *node) (nd, nf int) {
+	return p.printTree(node, &Stack{})
+}
+
+func (p *treePrinter) printTree(node *node, stack *Stack) (nd, nf int) {
+	//	fmt.Printf("stack=[%s] len=%d\n", stack.String(), stack.Len())
+
+	if stack.Len() > 0 {
+		p.logger.Info("%s ", strings.Join(stack.Reverse(), " "))
+	}
+
+	p.logger.Info("%s\n", p.label(node))
+
+	if node.Nodes == nil {
+		return 0, 1
+	}
+
+	top, _ := stack.Pop()
+	base := stack.Len()
+
+	nd = 0
+	nf = 0
+	zz := node.Len() - 1
+	z0 := 0
+
+	// the sort key was checked when the printer was made
+	arr, _ := node.children(p.sortBy, p.dirsFirst, p.reverse)
+
+	for _, v := range arr {
+
+		switch top {
+		case "":
+			if z0 < zz {
+				stack.Push(p.chars.branch)
+			} else {
+				stack.Push(p.chars.leaf)
+			}
+			break
+		case p.chars.branch:
+			if z0 < zz {
+				stack.Push(p.chars.trunk, p.chars.branch)
+			} else {
+				stack.Push(p.chars.trunk, p.chars.leaf)
+			}
+			break
+		case p.chars.leaf:
+			if z0 < zz {
+				stack.Push(p.chars.air, p.chars.branch)
+			} else {
+				stack.Push(p.chars.air, p.chars.leaf)
+			}
+			break
+		default:
+			p.logger.Info("\n---")
+			p.logger.Info("invalid stack: ")
+			p.logger.Info("stack=%v, top=%s\n", base, top)
+			os.Exit(9)
+		}
+
+		xd, xf := p.printTree(v, stack)
+
+		for stack.Len() > base {
+			stack.Pop()
+		}
+
+		nd = nd + xd
+		nf = nf + xf
+
+		z0++
+	}
+
+	return nd + 1, nf
+}
+
+// label annotates a node with the sizes asked for: --sizes for blobs, --du
+// for directories
+func (p *treePrinter) label(n *node) string {
+	name := n.Name
+	if p.color {
+		switch {
+		case n.Nodes != nil:
+			name = colorDir + name + colorReset
+		case n.Size >= largeBlobSize:
+			name = colorLarge + name + colorReset
+		}
+	}
+
+	size := fmt.Sprintf("%d", n.Size)
+	if p.humanSizes {
+		size = formatSize(n.Size)
+	}
+
+	switch {
+	case n.Nodes == nil && p.blobSizes:
+		return fmt.Sprintf("[%s]  %s", size, name)
+	case n.Nodes != nil && p.diskUsage:
+		return fmt.Sprintf("[%s in %d blobs]  %s", size, n.Blobs, name)
+	default:
+		return name
+	}
+}
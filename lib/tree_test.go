@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestTreeOrder(c *C) {
+	old := testBlob("b/old.log", 300)
+	old.Properties.LastModified = "Tue, 03 May 2016 10:00:00 GMT"
+	recent := testBlob("z.txt", 10)
+	recent.Properties.LastModified = "Wed, 04 May 2016 10:00:00 GMT"
+
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {testBlob("a/x.log", 5), testBlob("a/y.log", 1), old, testBlob("m.txt", 100), recent},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree"}, "logs", ""), Equals, ""+
+		".\n"+
+		"├── a\n"+
+		TRUNK+" ├── x.log\n"+
+		TRUNK+" └── y.log\n"+
+		"├── b\n"+
+		TRUNK+" └── old.log\n"+
+		"├── m.txt\n"+
+		"└── z.txt\n")
+
+	// Repeated runs print the same tree
+	for i := 0; i < 5; i++ {
+		c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree", SortBy: "size", DirsFirst: true}, "logs", ""), Equals, ""+
+			".\n"+
+			"├── b\n"+
+			TRUNK+" └── old.log\n"+
+			"├── a\n"+
+			TRUNK+" ├── x.log\n"+
+			TRUNK+" └── y.log\n"+
+			"├── m.txt\n"+
+			"└── z.txt\n")
+	}
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree", SortBy: "time", Reverse: true}, "logs", ""), Equals, ""+
+		".\n"+
+		"├── m.txt\n"+
+		"├── a\n"+
+		TRUNK+" ├── y.log\n"+
+		TRUNK+" └── x.log\n"+
+		"├── b\n"+
+		TRUNK+" └── old.log\n"+
+		"└── z.txt\n")
+
+	_, err := runCommand(c, context.Background(), cfg, &SimpleCommand{Command: "tree", SortBy: "owner"}, "", "logs")
+	c.Assert(err, Equals, ErrUnknownSortKey)
+}
+
+func (s *S) TestTreeSizes(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs": {
+			testBlob("2016/05/01/a.log", 1000),
+			testBlob("2016/05/02/b.log", 500),
+			testBlob("2016/c.log", 20),
+			testBlob("README", 4),
+		},
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree", Depth: 2, DiskUsage: true, BlobSizes: true}, "logs", "text"), Equals, ""+
+		"[1524 in 4 blobs]  .\n"+
+		"├── [1520 in 3 blobs]  2016\n"+
+		TRUNK+" ├── [1500 in 2 blobs]  05\n"+
+		TRUNK+" └── [20]  c.log\n"+
+		"└── [4]  README\n")
+
+	// A path roots the tree at its directory
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree", DiskUsage: true, HumanSizes: true}, "logs/2016/05/", "text"), Equals, ""+
+		"[1.50 KB in 2 blobs]  2016/05\n"+
+		"├── [1.00 KB in 1 blobs]  01\n"+
+		TRUNK+" └── a.log\n"+
+		"└── [500 B in 1 blobs]  02\n"+
+		AIR+" └── b.log\n")
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree"}, "logs/2016/c", "text"), Equals, "2016\n└── c.log\n")
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree", Depth: 1}, "logs/2016/", "json"), Equals,
+		`{"storageAccount":"acct","container":"logs","tree":{"Name":"2016","Nodes":{`+
+			`"05":{"Name":"05","Nodes":{},"Size":1500,"Blobs":2},`+
+			`"c.log":{"Name":"c.log","Nodes":null,"Size":20}},"Size":1520,"Blobs":3}}`+"\n")
+}
+
+func (s *S) TestTreePrinter(c *C) {
+	big := testBlob("big.iso", 2*largeBlobSize)
+	arr := blobs{newBlob(testBlob("a/b/c.txt", 1)), newBlob(testBlob("a/d.txt", 2)), newBlob(big)}
+	root := buildTree(arr, "", 0)
+
+	lg := &bufferLogger{}
+	p := &treePrinter{logger: lg, chars: treeCharsets["ascii"]}
+	nd, nf := p.printTree(root, &Stack{})
+	c.Assert(nd, Equals, 3)
+	c.Assert(nf, Equals, 3)
+	c.Assert(lg.String(), Equals, ""+
+		".\n"+
+		"|-- a\n"+
+		"|   |-- b\n"+
+		"|   |   `-- c.txt\n"+
+		"|   `-- d.txt\n"+
+		"`-- big.iso\n")
+
+	lg = &bufferLogger{}
+	p = &treePrinter{logger: lg, chars: treeCharsets["unicode"], color: true}
+	root.pruneBlobs()
+	p.printRoot(root)
+	c.Assert(lg.String(), Equals, ""+
+		colorDir+"."+colorReset+"\n"+
+		"└── "+colorDir+"a"+colorReset+"\n"+
+		AIR+" └── "+colorDir+"b"+colorReset+"\n")
+
+	c.Assert(p.label(&node{Name: "big.iso", Size: largeBlobSize}), Equals, colorLarge+"big.iso"+colorReset)
+	c.Assert(p.label(&node{Name: "small.txt", Size: 10}), Equals, "small.txt")
+
+	cmd := &SimpleCommand{Charset: "ebcdic"}
+	_, err := cmd.newTreePrinter()
+	c.Assert(err, Equals, ErrUnknownCharset)
+}
+
+func (s *S) TestTreeAccount(c *C) {
+	svc := newBlobService(map[string][]storage.Blob{
+		"logs":    {testBlob("2016/a.log", 10), testBlob("b.log", 5)},
+		"logs-eu": {testBlob("c.log", 1)},
+		"www":     {testBlob("index.html", 100)},
+		"empty":   nil,
+	})
+	defer svc.Close()
+	cfg, restore := svc.config()
+	defer restore()
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree", DiskUsage: true}, "", ""), Equals, ""+
+		"[116 in 4 blobs]  .\n"+
+		"├── [0 in 0 blobs]  empty\n"+
+		"├── [15 in 2 blobs]  logs\n"+
+		TRUNK+" ├── [10 in 1 blobs]  2016\n"+
+		TRUNK+" "+TRUNK+" └── a.log\n"+
+		TRUNK+" └── b.log\n"+
+		"├── [1 in 1 blobs]  logs-eu\n"+
+		TRUNK+" └── c.log\n"+
+		"└── [100 in 1 blobs]  www\n"+
+		AIR+" └── index.html\n")
+
+	// A prefix of several containers, rather than one
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree", Depth: 1}, "log", ""), Equals, ".\n├── logs\n└── logs-eu\n")
+
+	// A container's name still means just that container
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree", Depth: 1}, "logs", ""), Equals, ".\n├── 2016\n└── b.log\n")
+
+	c.Assert(runSimple(c, cfg, &SimpleCommand{Command: "tree", Format: "{{.Name}}"}, "", ""), Equals, "logs-eu/c.log\nlogs/2016/a.log\nlogs/b.log\nwww/index.html\n")
+
+	// A name that matches no container is an error, not an empty tree
+	_, err := runCommand(c, context.Background(), cfg, &SimpleCommand{Command: "tree"}, "", "nosuch")
+	c.Assert(err, Equals, ErrContainerNotFound)
+}